@@ -0,0 +1,553 @@
+// Copyright 2019 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an ISC-style
+// license that can be found in the LICENSE file.
+
+package hashmap
+
+import (
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"unsafe"
+
+	"github.com/zeebo/xxh3"
+)
+
+// Codec encodes and decodes keys or values of type T for MarshalBinaryWith
+// and UnmarshalBinaryWith.
+type Codec[T any] interface {
+	// Append appends the encoded value to dst and returns the extended
+	// buffer.
+	Append(dst []byte, v T) ([]byte, error)
+	// Decode decodes a value from the start of src and returns it with the
+	// number of bytes that were read.
+	Decode(src []byte) (v T, n int, err error)
+}
+
+// The binary format is a fixed size header that is followed by either the
+// raw bucket array, when the keys and values are fixed-size types, or by each
+// key and value encoded using its codec.
+//
+//	magic    [4]byte "HMAP"
+//	version  uint8
+//	flags    uint8
+//	         [2]byte reserved
+//	seed     uint64
+//	length   uint64  number of values
+//	nbuckets uint64  number of raw buckets, or zero
+//	ksize    uint32  size of a fixed-size key, or varSize
+//	vsize    uint32  size of a fixed-size value, or varSize
+//	esize    uint32  size of a raw bucket
+//	types    uint32  fingerprint of the key and value types
+//
+// The header fields are little-endian, and the header size keeps the raw
+// buckets aligned.
+const (
+	binMagic      = "HMAP"
+	binVersion    = 2
+	binHeaderSize = 48
+	varSize       = ^uint32(0)
+)
+
+const (
+	binFlagRaw       = 1 << iota // the body is the raw bucket array
+	binFlagNative                // the body uses the native memory layout
+	binFlagBigEndian             // the native byte order is big-endian
+	binFlagHasher                // the hashes are from a custom hasher
+)
+
+var errBinaryFormat = errors.New("hashmap: invalid binary format")
+
+var bigEndian = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 0
+}()
+
+type binHeader struct {
+	flags    uint8
+	seed     uint64
+	length   uint64
+	nbuckets uint64
+	ksize    uint32
+	vsize    uint32
+	esize    uint32
+	types    uint32
+}
+
+func (h *binHeader) append(dst []byte) []byte {
+	dst = append(dst, binMagic...)
+	dst = append(dst, binVersion, h.flags, 0, 0)
+	dst = binary.LittleEndian.AppendUint64(dst, h.seed)
+	dst = binary.LittleEndian.AppendUint64(dst, h.length)
+	dst = binary.LittleEndian.AppendUint64(dst, h.nbuckets)
+	dst = binary.LittleEndian.AppendUint32(dst, h.ksize)
+	dst = binary.LittleEndian.AppendUint32(dst, h.vsize)
+	dst = binary.LittleEndian.AppendUint32(dst, h.esize)
+	return binary.LittleEndian.AppendUint32(dst, h.types)
+}
+
+func (h *binHeader) decode(data []byte) error {
+	if len(data) < binHeaderSize || string(data[:4]) != binMagic {
+		return errBinaryFormat
+	}
+	if data[4] != binVersion {
+		return fmt.Errorf("hashmap: unsupported binary version %d", data[4])
+	}
+	h.flags = data[5]
+	h.seed = binary.LittleEndian.Uint64(data[8:])
+	h.length = binary.LittleEndian.Uint64(data[16:])
+	h.nbuckets = binary.LittleEndian.Uint64(data[24:])
+	h.ksize = binary.LittleEndian.Uint32(data[32:])
+	h.vsize = binary.LittleEndian.Uint32(data[36:])
+	h.esize = binary.LittleEndian.Uint32(data[40:])
+	h.types = binary.LittleEndian.Uint32(data[44:])
+	if h.flags&binFlagNative != 0 &&
+		(h.flags&binFlagBigEndian != 0) != bigEndian {
+		return errors.New("hashmap: binary data has a different byte order")
+	}
+	return nil
+}
+
+// checkTypes returns an error when the data was written for other key or
+// value types. It only matters for the native layout, because other types
+// with the same size would be loaded from memory that has a different
+// meaning, such as an int as a float64.
+func (h *binHeader) checkTypes(ksize, vsize, types uint32) error {
+	if h.ksize != ksize || h.vsize != vsize ||
+		(h.flags&binFlagNative != 0 && h.types != types) {
+		return errors.New("hashmap: binary data has different types")
+	}
+	return nil
+}
+
+// typesFingerprint returns a fingerprint of the names and memory layouts of
+// the key and value types.
+func typesFingerprint[K, V any]() uint32 {
+	var b []byte
+	b = appendTypeLayout(b, reflect.TypeOf((*K)(nil)).Elem())
+	b = append(b, ',')
+	b = appendTypeLayout(b, reflect.TypeOf((*V)(nil)).Elem())
+	return uint32(xxh3.Hash(b))
+}
+
+func appendTypeLayout(dst []byte, t reflect.Type) []byte {
+	dst = fmt.Appendf(dst, "%s:%d", t, t.Size())
+	switch t.Kind() {
+	case reflect.Array:
+		dst = append(dst, '[')
+		dst = appendTypeLayout(dst, t.Elem())
+		dst = append(dst, ']')
+	case reflect.Struct:
+		dst = append(dst, '{')
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			dst = fmt.Appendf(dst, "%s@%d ", f.Name, f.Offset)
+			dst = appendTypeLayout(dst, f.Type)
+			dst = append(dst, ';')
+		}
+		dst = append(dst, '}')
+	}
+	return dst
+}
+
+// fixedSize returns the size of a type that can be copied as raw memory, or
+// varSize when the type has pointers.
+func fixedSize(t reflect.Type) uint32 {
+	if !isFixed(t) {
+		return varSize
+	}
+	return uint32(t.Size())
+}
+
+func isFixed(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16,
+		reflect.Uint32, reflect.Uint64, reflect.Uintptr, reflect.Float32,
+		reflect.Float64, reflect.Complex64, reflect.Complex128:
+		return true
+	case reflect.Array:
+		return isFixed(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if !isFixed(t.Field(i).Type) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// rawCodec encodes a fixed-size type as its memory.
+type rawCodec[T any] struct{}
+
+func (rawCodec[T]) Append(dst []byte, v T) ([]byte, error) {
+	return append(dst, unsafe.Slice((*byte)(unsafe.Pointer(&v)),
+		unsafe.Sizeof(v))...), nil
+}
+
+func (rawCodec[T]) Decode(src []byte) (v T, n int, err error) {
+	n = int(unsafe.Sizeof(v))
+	if len(src) < n {
+		return v, 0, errBinaryFormat
+	}
+	copy(unsafe.Slice((*byte)(unsafe.Pointer(&v)), n), src)
+	return v, n, nil
+}
+
+// stringCodec encodes types with an underlying string type with a length
+// prefix.
+type stringCodec[T any] struct{}
+
+func (stringCodec[T]) Append(dst []byte, v T) ([]byte, error) {
+	s := *(*string)(unsafe.Pointer(&v))
+	dst = binary.AppendUvarint(dst, uint64(len(s)))
+	return append(dst, s...), nil
+}
+
+func (stringCodec[T]) Decode(src []byte) (v T, n int, err error) {
+	b, n, err := decodeBytes(src)
+	if err != nil {
+		return v, 0, err
+	}
+	*(*string)(unsafe.Pointer(&v)) = string(b)
+	return v, n, nil
+}
+
+// bytesCodec encodes types with an underlying []byte type with a length
+// prefix.
+type bytesCodec[T any] struct{}
+
+func (bytesCodec[T]) Append(dst []byte, v T) ([]byte, error) {
+	b := *(*[]byte)(unsafe.Pointer(&v))
+	dst = binary.AppendUvarint(dst, uint64(len(b)))
+	return append(dst, b...), nil
+}
+
+func (bytesCodec[T]) Decode(src []byte) (v T, n int, err error) {
+	b, n, err := decodeBytes(src)
+	if err != nil {
+		return v, 0, err
+	}
+	*(*[]byte)(unsafe.Pointer(&v)) = append([]byte(nil), b...)
+	return v, n, nil
+}
+
+// decodeBytes decodes bytes with a length prefix.
+func decodeBytes(src []byte) ([]byte, int, error) {
+	sz, n := binary.Uvarint(src)
+	if n <= 0 || sz > uint64(len(src)-n) {
+		return nil, 0, errBinaryFormat
+	}
+	return src[n : n+int(sz)], n + int(sz), nil
+}
+
+// marshalerCodec encodes types that implement encoding.BinaryMarshaler with
+// a length prefix.
+type marshalerCodec[T any] struct{}
+
+func (marshalerCodec[T]) Append(dst []byte, v T) ([]byte, error) {
+	data, err := any(v).(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return dst, err
+	}
+	dst = binary.AppendUvarint(dst, uint64(len(data)))
+	return append(dst, data...), nil
+}
+
+func (marshalerCodec[T]) Decode(src []byte) (v T, n int, err error) {
+	b, n, err := decodeBytes(src)
+	if err != nil {
+		return v, 0, err
+	}
+	err = any(&v).(encoding.BinaryUnmarshaler).UnmarshalBinary(b)
+	return v, n, err
+}
+
+// defaultCodec returns the codec that is used for a type when none is
+// provided.
+func defaultCodec[T any]() (Codec[T], error) {
+	var v T
+	t := reflect.TypeOf(&v).Elem()
+	if isFixed(t) {
+		return rawCodec[T]{}, nil
+	}
+	_, ok1 := any(v).(encoding.BinaryMarshaler)
+	_, ok2 := any(&v).(encoding.BinaryUnmarshaler)
+	switch {
+	case ok1 && ok2:
+		return marshalerCodec[T]{}, nil
+	case t.Kind() == reflect.String:
+		return stringCodec[T]{}, nil
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		return bytesCodec[T]{}, nil
+	}
+	return nil, fmt.Errorf("hashmap: no binary codec for type %v", t)
+}
+
+// MarshalBinary encodes the map into binary form.
+// Keys and values that are fixed-size types, such as integers or structs
+// of integers, are written as the raw bucket array, which allows for loading
+// the map without rehashing. Strings, byte slices, and types that implement
+// encoding.BinaryMarshaler are also supported. Use MarshalBinaryWith for
+// other types.
+func (m *Map[K, V]) MarshalBinary() ([]byte, error) {
+	return m.MarshalBinaryWith(nil, nil)
+}
+
+// MarshalBinaryWith is like MarshalBinary but uses the provided codecs for
+// encoding keys and values. A nil codec uses the default.
+func (m *Map[K, V]) MarshalBinaryWith(kc Codec[K], vc Codec[V]) ([]byte, error) {
+	h := m.binHeader()
+	if kc == nil && vc == nil && m.rawBinary() {
+		h.flags |= binFlagRaw | binFlagNative
+		h.nbuckets = uint64(len(m.buckets))
+		data := make([]byte, 0, binHeaderSize+len(m.buckets)*int(h.esize))
+		data = h.append(data)
+		return append(data, bucketBytes(m.buckets)...), nil
+	}
+	var err error
+	if kc == nil {
+		if kc, err = defaultCodec[K](); err != nil {
+			return nil, err
+		}
+		if _, ok := kc.(rawCodec[K]); ok {
+			h.flags |= binFlagNative
+		}
+	}
+	if vc == nil {
+		if vc, err = defaultCodec[V](); err != nil {
+			return nil, err
+		}
+		if _, ok := vc.(rawCodec[V]); ok {
+			h.flags |= binFlagNative
+		}
+	}
+	data := h.append(nil)
+	for _, buckets := range m.tables() {
+		for i := range buckets {
+			if buckets[i].dib() == 0 {
+				continue
+			}
+			if data, err = kc.Append(data, buckets[i].key); err != nil {
+				return nil, err
+			}
+			if data, err = vc.Append(data, buckets[i].value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return data, nil
+}
+
+func (m *Map[K, V]) binHeader() binHeader {
+	h := binHeader{
+		seed:   m.seed,
+		length: uint64(m.length),
+		ksize:  fixedSize(reflect.TypeOf((*K)(nil)).Elem()),
+		vsize:  fixedSize(reflect.TypeOf((*V)(nil)).Elem()),
+		esize:  uint32(unsafe.Sizeof(entry[K, V]{})),
+		types:  typesFingerprint[K, V](),
+	}
+	if bigEndian {
+		h.flags |= binFlagBigEndian
+	}
+	if m.hasher != nil {
+		h.flags |= binFlagHasher
+	}
+	return h
+}
+
+// rawBinary returns true when the map can be encoded as its raw buckets.
+func (m *Map[K, V]) rawBinary() bool {
+	var k K
+	var v V
+	return isFixed(reflect.TypeOf(&k).Elem()) &&
+		isFixed(reflect.TypeOf(&v).Elem()) && m.old == nil &&
+		len(m.buckets) > 0
+}
+
+// WriteTo writes the map to w using the same binary form as MarshalBinary,
+// but without copying the buckets into memory first.
+// The file that is written for a map with fixed-size keys and values can be
+// opened with OpenReadOnly.
+func (m *Map[K, V]) WriteTo(w io.Writer) (n int64, err error) {
+	if m.old != nil {
+		// Finish resizing on a copy, so the raw buckets can be written.
+		m = m.Copy()
+		m.own()
+		m.moveAll()
+	}
+	if !m.rawBinary() {
+		data, err := m.MarshalBinary()
+		if err != nil {
+			return 0, err
+		}
+		nn, err := w.Write(data)
+		return int64(nn), err
+	}
+	h := m.binHeader()
+	h.flags |= binFlagRaw | binFlagNative
+	h.nbuckets = uint64(len(m.buckets))
+	nn, err := w.Write(h.append(nil))
+	n += int64(nn)
+	if err != nil {
+		return n, err
+	}
+	nn, err = w.Write(bucketBytes(m.buckets))
+	return n + int64(nn), err
+}
+
+// bucketBytes returns the memory of the buckets.
+func bucketBytes[K comparable, V any](buckets []entry[K, V]) []byte {
+	if len(buckets) == 0 {
+		return nil
+	}
+	return unsafe.Slice((*byte)(unsafe.Pointer(&buckets[0])),
+		len(buckets)*int(unsafe.Sizeof(buckets[0])))
+}
+
+// UnmarshalBinary decodes the map from binary form, replacing all of its
+// keys and values. See MarshalBinary for the supported types.
+func (m *Map[K, V]) UnmarshalBinary(data []byte) error {
+	return m.UnmarshalBinaryWith(data, nil, nil)
+}
+
+// UnmarshalBinaryWith is like UnmarshalBinary but uses the provided codecs
+// for decoding keys and values. A nil codec uses the default. The codecs must
+// match the ones used for encoding.
+func (m *Map[K, V]) UnmarshalBinaryWith(data []byte, kc Codec[K], vc Codec[V],
+) error {
+	var h binHeader
+	if err := h.decode(data); err != nil {
+		return err
+	}
+	err := h.checkTypes(fixedSize(reflect.TypeOf((*K)(nil)).Elem()),
+		fixedSize(reflect.TypeOf((*V)(nil)).Elem()), typesFingerprint[K, V]())
+	if err != nil {
+		return err
+	}
+	data = data[binHeaderSize:]
+	if h.flags&binFlagRaw != 0 {
+		buckets, err := decodeBuckets[K, V](data, &h)
+		if err != nil {
+			return err
+		}
+		m.load(buckets, &h)
+		return nil
+	}
+	if kc == nil {
+		if kc, err = defaultCodec[K](); err != nil {
+			return err
+		}
+	}
+	if vc == nil {
+		if vc, err = defaultCodec[V](); err != nil {
+			return err
+		}
+	}
+	if h.length > uint64(len(data)) && h.length > 1 {
+		// Each key needs at least one byte, except for zero-size keys, of
+		// which there can only be one.
+		return errBinaryFormat
+	}
+	m.Clear()
+	m.Reserve(int(h.length))
+	for i := uint64(0); i < h.length; i++ {
+		key, n, err := kc.Decode(data)
+		if err != nil {
+			return err
+		}
+		data = data[n:]
+		value, n, err := vc.Decode(data)
+		if err != nil {
+			return err
+		}
+		data = data[n:]
+		m.Set(key, value)
+	}
+	if len(data) != 0 || m.length != int(h.length) {
+		return errBinaryFormat
+	}
+	return nil
+}
+
+// decodeBuckets returns a raw bucket array after validating it.
+func decodeBuckets[K comparable, V any](data []byte, h *binHeader,
+) ([]entry[K, V], error) {
+	n := h.nbuckets
+	if h.esize != uint32(unsafe.Sizeof(entry[K, V]{})) || n < 8 ||
+		n&(n-1) != 0 || n > uint64(len(data))/uint64(h.esize) ||
+		uint64(len(data)) != n*uint64(h.esize) || h.length >= n {
+		return nil, errBinaryFormat
+	}
+	buckets := make([]entry[K, V], n)
+	copy(bucketBytes(buckets), data)
+	// An incorrect count could leave the buckets without an empty one,
+	// which would make a search loop forever.
+	var length uint64
+	for i := range buckets {
+		if buckets[i].dib() > 0 {
+			length++
+		}
+	}
+	if length != h.length {
+		return nil, errBinaryFormat
+	}
+	return buckets, nil
+}
+
+// load replaces the map with the raw buckets.
+func (m *Map[K, V]) load(buckets []entry[K, V], h *binHeader) {
+	if len(m.buckets) == 0 {
+		m.init(0)
+	}
+	if h.flags&binFlagHasher != 0 || m.hasher != nil {
+		// The hashes might not be the same, so each key is added again.
+		m.Clear()
+		m.Reserve(int(h.length))
+		for i := range buckets {
+			if buckets[i].dib() > 0 {
+				m.Set(buckets[i].key, buckets[i].value)
+			}
+		}
+		return
+	}
+	m.refs.Add(-1)
+	m.old = nil
+	m.setBuckets(buckets)
+	m.length = int(h.length)
+	m.seed = h.seed
+	if m.length >= m.growAt {
+		// The map was written using a higher MaxLoadFactor.
+		m.resize(m.sizeFor(m.length))
+	}
+}
+
+// MarshalBinary encodes the set into binary form.
+// See Map.MarshalBinary for more information.
+func (tr *Set[K]) MarshalBinary() ([]byte, error) {
+	return tr.base.MarshalBinaryWith(nil, nil)
+}
+
+// MarshalBinaryWith is like MarshalBinary but uses the provided codec for
+// encoding keys. A nil codec uses the default.
+func (tr *Set[K]) MarshalBinaryWith(kc Codec[K]) ([]byte, error) {
+	return tr.base.MarshalBinaryWith(kc, nil)
+}
+
+// UnmarshalBinary decodes the set from binary form, replacing all of its
+// keys.
+func (tr *Set[K]) UnmarshalBinary(data []byte) error {
+	return tr.base.UnmarshalBinaryWith(data, nil, nil)
+}
+
+// UnmarshalBinaryWith is like UnmarshalBinary but uses the provided codec
+// for decoding keys. A nil codec uses the default.
+func (tr *Set[K]) UnmarshalBinaryWith(data []byte, kc Codec[K]) error {
+	return tr.base.UnmarshalBinaryWith(data, kc, nil)
+}
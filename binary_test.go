@@ -0,0 +1,212 @@
+// Copyright 2019 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an ISC-style
+// license that can be found in the LICENSE file.
+
+package hashmap
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+type point struct {
+	X, Y int32
+	Z    float64
+}
+
+func TestBinaryRaw(t *testing.T) {
+	m := New[point, int64](0)
+	for i := 0; i < 1000; i++ {
+		m.Set(point{int32(i), int32(-i), float64(i) / 2}, int64(i))
+	}
+	data, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[5]&binFlagRaw == 0 {
+		t.Fatal("expected raw buckets")
+	}
+	var m2 Map[point, int64]
+	if err := m2.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	// The buckets were loaded as is, so the hashes must match.
+	if m2.seed != m.seed || m2.Len() != m.Len() {
+		t.Fatalf("expected %v, got %v", m.Len(), m2.Len())
+	}
+	for i := 0; i < 1000; i++ {
+		if v, ok := m2.Get(point{int32(i), int32(-i), float64(i) / 2}); !ok ||
+			v != int64(i) {
+			t.Fatalf("expected %v, got %v", i, v)
+		}
+	}
+	m2.Set(point{-1, -1, -1}, -1)
+	if m.Len() != 1000 || m2.Len() != 1001 {
+		t.Fatalf("expected %v, got %v", 1001, m2.Len())
+	}
+
+	// A map with a custom hasher rehashes the keys.
+	m3 := NewWithHasher[point, int64](0, func(key point) uint64 {
+		return uint64(key.X) * 0x9E3779B97F4A7C15
+	})
+	if err := m3.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := m3.Get(point{7, -7, 3.5}); !ok || v != 7 || m3.Len() != 1000 {
+		t.Fatalf("expected %v, got %v", 7, v)
+	}
+
+	// corrupt data
+	for _, n := range []int{0, 10, binHeaderSize, len(data) - 1} {
+		if err := m2.UnmarshalBinary(data[:n]); err == nil {
+			t.Fatalf("expected error for %d bytes", n)
+		}
+	}
+	bad := append([]byte(nil), data...)
+	binary.LittleEndian.PutUint64(bad[16:], uint64(len(m.buckets)))
+	if err := m2.UnmarshalBinary(bad); !errors.Is(err, errBinaryFormat) {
+		t.Fatalf("expected %v, got %v", errBinaryFormat, err)
+	}
+	var wrong Map[point, int32]
+	if err := wrong.UnmarshalBinary(data); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestBinaryTypes(t *testing.T) {
+	m := New[int64, int64](0)
+	m.Set(1, 42)
+	data, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// types with the same size
+	var m2 Map[int64, float64]
+	if err := m2.UnmarshalBinary(data); err == nil {
+		t.Fatal("expected error")
+	}
+	var m3 Map[uint64, int64]
+	if err := m3.UnmarshalBinary(data); err == nil {
+		t.Fatal("expected error")
+	}
+	var m4 Map[int64, int64]
+	if err := m4.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	// types with codecs
+	m5 := New[string, int64](0)
+	m5.Set("a", 42)
+	if data, err = m5.MarshalBinary(); err != nil {
+		t.Fatal(err)
+	}
+	var m6 Map[string, float64]
+	if err := m6.UnmarshalBinary(data); err == nil {
+		t.Fatal("expected error")
+	}
+	// an older version
+	data[4] = 1
+	var m7 Map[string, int64]
+	if err := m7.UnmarshalBinary(data); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestBinaryCodecs(t *testing.T) {
+	m := New[string, []byte](0)
+	for i := 0; i < 1000; i++ {
+		m.Set(k(i), []byte(k(i*2)))
+	}
+	data, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m2 Map[string, []byte]
+	if err := m2.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		if v, ok := m2.Get(k(i)); !ok || string(v) != k(i*2) {
+			t.Fatalf("expected %v, got %v", k(i*2), v)
+		}
+	}
+
+	// types that implement encoding.BinaryMarshaler
+	m3 := New[int, time.Time](0)
+	now := time.Now()
+	m3.Set(1, now)
+	data, err = m3.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m4 Map[int, time.Time]
+	if err := m4.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := m4.Get(1); !v.Equal(now) {
+		t.Fatalf("expected %v, got %v", now, v)
+	}
+
+	// custom codec
+	m5 := New[int, *int](0)
+	if _, err := m5.MarshalBinary(); err == nil {
+		t.Fatal("expected error")
+	}
+	for i := 0; i < 100; i++ {
+		i := i
+		m5.Set(i, &i)
+	}
+	data, err = m5.MarshalBinaryWith(nil, ptrCodec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m6 Map[int, *int]
+	if err := m6.UnmarshalBinaryWith(data, nil, ptrCodec{}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if v, ok := m6.Get(i); !ok || *v != i {
+			t.Fatalf("expected %v, got %v", i, v)
+		}
+	}
+}
+
+type ptrCodec struct{}
+
+func (ptrCodec) Append(dst []byte, v *int) ([]byte, error) {
+	return binary.AppendVarint(dst, int64(*v)), nil
+}
+
+func (ptrCodec) Decode(src []byte) (*int, int, error) {
+	x, n := binary.Varint(src)
+	if n <= 0 {
+		return nil, 0, errBinaryFormat
+	}
+	v := int(x)
+	return &v, n, nil
+}
+
+func TestBinarySet(t *testing.T) {
+	for _, opts := range []*Options{nil, {Incremental: true}} {
+		s := NewSetWithOptions[int](0, opts)
+		for i := 0; i < 1000; i++ {
+			s.Insert(i)
+		}
+		data, err := s.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var s2 Set[int]
+		if err := s2.UnmarshalBinary(data); err != nil {
+			t.Fatal(err)
+		}
+		if s2.Len() != 1000 || !s2.Contains(999) || s2.Contains(1000) {
+			t.Fatalf("expected %v, got %v", 1000, s2.Len())
+		}
+		var m Map[int, int]
+		if err := m.UnmarshalBinary(data); err == nil {
+			t.Fatal("expected error")
+		}
+	}
+}
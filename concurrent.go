@@ -0,0 +1,131 @@
+// Copyright 2019 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an ISC-style
+// license that can be found in the LICENSE file.
+
+package hashmap
+
+import (
+	"math/bits"
+	"runtime"
+	"sync"
+)
+
+// ConcurrentMap is a hashmap that is safe for concurrent use by multiple
+// goroutines. The keys are partitioned across a number of shards using the
+// high bits of their hashes, and each shard is a Map guarded by its own
+// lock.
+type ConcurrentMap[K comparable, V any] struct {
+	shift  uint // shifts a hash to its shard index
+	shards []shard[K, V]
+}
+
+type shard[K comparable, V any] struct {
+	mu sync.RWMutex
+	m  Map[K, V]
+	_  [64]byte // avoid false sharing between shards
+}
+
+// NewConcurrentMap returns a new ConcurrentMap with the provided number of
+// shards, which is rounded up to a power of two. Using zero will choose the
+// number of shards based on GOMAXPROCS.
+func NewConcurrentMap[K comparable, V any](shards int) *ConcurrentMap[K, V] {
+	if shards <= 0 {
+		shards = runtime.GOMAXPROCS(0) * 4
+	}
+	nbits := 0
+	for 1<<nbits < shards && nbits < 16 {
+		nbits++
+	}
+	// A hash has hashBitSize bits, or fewer on 32-bit platforms where it's
+	// truncated to an int.
+	c := &ConcurrentMap[K, V]{
+		shift:  uint(min(hashBitSize, bits.UintSize) - nbits),
+		shards: make([]shard[K, V], 1<<nbits),
+	}
+	// All shards use the same seed, which allows for each key to be hashed
+	// once for both finding its shard and its bucket.
+	for i := range c.shards {
+		c.shards[i].m.init(0)
+		c.shards[i].m.seed = c.shards[0].m.seed
+	}
+	return c
+}
+
+// shard returns the hash of a key and the shard that owns it.
+func (c *ConcurrentMap[K, V]) shard(key K) (int, *shard[K, V]) {
+	hash := c.shards[0].m.hash(key)
+	return hash, &c.shards[uint(hash)>>c.shift]
+}
+
+// Get returns a value for a key.
+// Returns false when no value has been assign for key.
+func (c *ConcurrentMap[K, V]) Get(key K) (V, bool) {
+	hash, s := c.shard(key)
+	s.mu.RLock()
+	value, ok := s.m.getHashed(hash, key)
+	s.mu.RUnlock()
+	return value, ok
+}
+
+// Set assigns a value to a key.
+// Returns the previous value, or false when no value was assigned.
+func (c *ConcurrentMap[K, V]) Set(key K, value V) (V, bool) {
+	hash, s := c.shard(key)
+	s.mu.Lock()
+	prev, ok := s.m.setHashed(hash, key, value)
+	s.mu.Unlock()
+	return prev, ok
+}
+
+// Delete deletes a value for a key.
+// Returns the deleted value, or false when no value was assigned.
+func (c *ConcurrentMap[K, V]) Delete(key K) (V, bool) {
+	hash, s := c.shard(key)
+	s.mu.Lock()
+	prev, ok := s.m.deleteHashed(hash, key)
+	s.mu.Unlock()
+	return prev, ok
+}
+
+// Compute calls fn with the current value for a key, and assigns the value
+// that fn returns. See Map.Compute for more information.
+// The shard that owns the key is locked while fn is called, so fn must not
+// access the map.
+func (c *ConcurrentMap[K, V]) Compute(key K,
+	fn func(old V, exists bool) (value V, keep bool),
+) (V, bool) {
+	hash, s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.m.computeHashed(hash, key, fn)
+}
+
+// Len returns the number of values in map.
+// The result may be stale when there are concurrent writers.
+func (c *ConcurrentMap[K, V]) Len() int {
+	var n int
+	for i := range c.shards {
+		c.shards[i].mu.RLock()
+		n += c.shards[i].m.Len()
+		c.shards[i].mu.RUnlock()
+	}
+	return n
+}
+
+// Range iterates over all key/values.
+// Each shard is copied while locked and the copy is then iterated without
+// holding the lock, so the key/values for each shard are a consistent
+// snapshot, and it's safe to call other methods, including Set and Delete,
+// from fn.
+func (c *ConcurrentMap[K, V]) Range(fn func(key K, value V) bool) {
+	for i := range c.shards {
+		c.shards[i].mu.RLock()
+		m := c.shards[i].m.Copy()
+		c.shards[i].mu.RUnlock()
+		for key, value := range m.All() {
+			if !fn(key, value) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2019 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an ISC-style
+// license that can be found in the LICENSE file.
+
+package hashmap
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestConcurrentMap(t *testing.T) {
+	c := NewConcurrentMap[string, int](0)
+	const N = 10000
+	const G = 8
+	var wg sync.WaitGroup
+	for g := 0; g < G; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < N; i++ {
+				key := k(g*N + i)
+				if _, ok := c.Set(key, i); ok {
+					t.Errorf("expected false")
+				}
+				if v, ok := c.Get(key); !ok || v != i {
+					t.Errorf("expected %v, got %v", i, v)
+				}
+				// a counter shared by all goroutines
+				c.Compute("counter", func(old int, exists bool) (int, bool) {
+					return old + 1, true
+				})
+			}
+		}(g)
+	}
+	wg.Wait()
+	if c.Len() != N*G+1 {
+		t.Fatalf("expected %v, got %v", N*G+1, c.Len())
+	}
+	if v, _ := c.Get("counter"); v != N*G {
+		t.Fatalf("expected %v, got %v", N*G, v)
+	}
+	c.Delete("counter")
+	// delete while ranging
+	var n int
+	c.Range(func(key string, value int) bool {
+		if value != add(key, 0)%N {
+			t.Fatalf("expected %v, got %v", add(key, 0)%N, value)
+		}
+		if value%2 == 0 {
+			if _, ok := c.Delete(key); !ok {
+				t.Fatalf("expected true")
+			}
+		}
+		n++
+		return true
+	})
+	if n != N*G {
+		t.Fatalf("expected %v, got %v", N*G, n)
+	}
+	if c.Len() != N*G/2 {
+		t.Fatalf("expected %v, got %v", N*G/2, c.Len())
+	}
+	n = 0
+	c.Range(func(key string, value int) bool {
+		n++
+		return n < 10
+	})
+	if n != 10 {
+		t.Fatalf("expected %v, got %v", 10, n)
+	}
+}
+
+func TestConcurrentMapShards(t *testing.T) {
+	for _, shards := range []int{1, 3, 64} {
+		c := NewConcurrentMap[int, int](shards)
+		for i := 0; i < 1000; i++ {
+			c.Set(i, i)
+		}
+		var used int
+		for i := range c.shards {
+			if c.shards[i].m.Len() > 0 {
+				used++
+			}
+		}
+		if len(c.shards) < shards || used != len(c.shards) {
+			t.Fatalf("expected %v, got %v", len(c.shards), used)
+		}
+		for i := 0; i < 1000; i++ {
+			if v, ok := c.Get(i); !ok || v != i {
+				t.Fatalf("expected %v, got %v", i, v)
+			}
+		}
+	}
+}
@@ -0,0 +1,415 @@
+// Copyright 2019 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an ISC-style
+// license that can be found in the LICENSE file.
+
+package hashmap
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SyncPolicy is when a DurableMap flushes its log to stable storage.
+type SyncPolicy int
+
+const (
+	// SyncAlways flushes the log after every write. A write is never lost
+	// once it returns, but each write waits for the disk.
+	SyncAlways SyncPolicy = iota
+	// SyncInterval flushes the log on the first write after the
+	// SyncInterval has passed. Writes since the last flush can be lost when
+	// the system crashes.
+	SyncInterval
+	// SyncNever leaves flushing the log to the operating system.
+	SyncNever
+)
+
+// DurableOptions for a DurableMap.
+// The zero value for each field uses the default.
+type DurableOptions[K comparable, V any] struct {
+	// Sync is when the log is flushed to stable storage.
+	// Default SyncAlways.
+	Sync SyncPolicy
+	// SyncInterval is the interval for SyncInterval. Default one second.
+	SyncInterval time.Duration
+	// SnapshotEvery is the number of log records that are written before
+	// the map is written to a new snapshot and the log is cleared. A
+	// snapshot that fails is tried again after another SnapshotEvery
+	// records. Default 100,000.
+	SnapshotEvery int
+	// KeyCodec and ValueCodec are the codecs for the log and snapshots.
+	// See Map.MarshalBinary for the types that have a default codec.
+	KeyCodec   Codec[K]
+	ValueCodec Codec[V]
+	// Map is the options for the map.
+	Map *Options
+}
+
+// DurableMap is a Map that is persisted to a directory. Each Set and Delete
+// is appended to a log file before the map is changed, and the map is
+// written to a snapshot file every so often. Opening the directory loads the
+// snapshot and replays the log, which recovers the map after a crash.
+//
+// It's not safe for concurrent use.
+type DurableMap[K comparable, V any] struct {
+	m        *Map[K, V]
+	dir      string
+	opts     DurableOptions[K, V]
+	log      *os.File
+	records  int   // number of records in the log
+	snapAt   int   // number of records for the next automatic snapshot
+	snapErr  error // the error of the last automatic snapshot
+	buf      []byte
+	lastSync time.Time
+	err      error // a write error, which makes the map unusable
+}
+
+const (
+	durableSnapshot = "snapshot"
+	durableLog      = "log"
+	recHeaderSize   = 8 // [len:4][crc:4]
+	recSet          = 1
+	recDelete       = 2
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var errDurableCorrupt = errors.New("hashmap: corrupt snapshot")
+
+// OpenDurable opens a DurableMap in a directory, which is created when it
+// does not exist. A log that ends with an incomplete or corrupt record, such
+// as after a crash, is truncated to its last valid record.
+func OpenDurable[K comparable, V any](dir string, opts *DurableOptions[K, V],
+) (*DurableMap[K, V], error) {
+	d := &DurableMap[K, V]{dir: dir}
+	if opts != nil {
+		d.opts = *opts
+	}
+	if d.opts.SyncInterval == 0 {
+		d.opts.SyncInterval = time.Second
+	}
+	if d.opts.SnapshotEvery == 0 {
+		d.opts.SnapshotEvery = 100_000
+	}
+	d.snapAt = d.opts.SnapshotEvery
+	var err error
+	if d.opts.KeyCodec == nil {
+		if d.opts.KeyCodec, err = defaultCodec[K](); err != nil {
+			return nil, err
+		}
+	}
+	if d.opts.ValueCodec == nil {
+		if d.opts.ValueCodec, err = defaultCodec[V](); err != nil {
+			return nil, err
+		}
+	}
+	if d.opts.Map != nil {
+		d.m = NewWithOptions[K, V](0, d.opts.Map)
+	} else {
+		d.m = New[K, V](0)
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	if err := d.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	end, err := d.replay()
+	if err != nil {
+		return nil, err
+	}
+	d.log, err = os.OpenFile(filepath.Join(dir, durableLog),
+		os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	// Remove the incomplete record, if any, so that new records follow the
+	// last valid one.
+	if err := d.log.Truncate(end); err != nil {
+		d.log.Close()
+		return nil, err
+	}
+	if _, err := d.log.Seek(end, 0); err != nil {
+		d.log.Close()
+		return nil, err
+	}
+	d.lastSync = time.Now()
+	return d, nil
+}
+
+func (d *DurableMap[K, V]) loadSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(d.dir, durableSnapshot))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	// The snapshot is followed by its checksum.
+	if len(data) < 4 {
+		return errDurableCorrupt
+	}
+	crc := binary.LittleEndian.Uint32(data[len(data)-4:])
+	data = data[:len(data)-4]
+	if crc32.Checksum(data, crcTable) != crc {
+		return errDurableCorrupt
+	}
+	return d.m.UnmarshalBinaryWith(data, d.opts.KeyCodec, d.opts.ValueCodec)
+}
+
+// replay applies the records in the log to the map.
+// Returns the end of the last valid record.
+func (d *DurableMap[K, V]) replay() (int64, error) {
+	data, err := os.ReadFile(filepath.Join(d.dir, durableLog))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	var end int
+	for {
+		rec := data[end:]
+		if len(rec) < recHeaderSize {
+			break
+		}
+		n := binary.LittleEndian.Uint32(rec)
+		crc := binary.LittleEndian.Uint32(rec[4:])
+		if n == 0 || uint64(n) > uint64(len(rec)-recHeaderSize) {
+			break
+		}
+		rec = rec[recHeaderSize : recHeaderSize+n]
+		if crc32.Checksum(rec, crcTable) != crc || !d.apply(rec) {
+			break
+		}
+		end += recHeaderSize + int(n)
+		d.records++
+	}
+	return int64(end), nil
+}
+
+// apply applies a log record to the map.
+// Returns false when the record is not valid.
+func (d *DurableMap[K, V]) apply(rec []byte) bool {
+	op := rec[0]
+	key, n, err := d.opts.KeyCodec.Decode(rec[1:])
+	if err != nil {
+		return false
+	}
+	rec = rec[1+n:]
+	switch op {
+	case recSet:
+		value, n, err := d.opts.ValueCodec.Decode(rec)
+		if err != nil || n != len(rec) {
+			return false
+		}
+		d.m.Set(key, value)
+	case recDelete:
+		if len(rec) != 0 {
+			return false
+		}
+		d.m.Delete(key)
+	default:
+		return false
+	}
+	return true
+}
+
+// Get returns a value for a key.
+// Returns false when no value has been assign for key.
+func (d *DurableMap[K, V]) Get(key K) (V, bool) {
+	return d.m.Get(key)
+}
+
+// Len returns the number of values in map.
+func (d *DurableMap[K, V]) Len() int {
+	return d.m.Len()
+}
+
+// Scan iterates over all key/values.
+// It's not safe to call or Set or Delete while scanning.
+func (d *DurableMap[K, V]) Scan(iter func(key K, value V) bool) {
+	d.m.Scan(iter)
+}
+
+// Set assigns a value to a key.
+// The map is not changed when the write to the log fails. The error of an
+// automatic snapshot is not returned, see SnapshotErr.
+func (d *DurableMap[K, V]) Set(key K, value V) error {
+	if err := d.write(recSet, key, &value); err != nil {
+		return err
+	}
+	d.m.Set(key, value)
+	d.maybeSnapshot()
+	return nil
+}
+
+// Delete deletes a value for a key.
+// The map is not changed when the write to the log fails. The error of an
+// automatic snapshot is not returned, see SnapshotErr.
+func (d *DurableMap[K, V]) Delete(key K) error {
+	if _, ok := d.m.Get(key); !ok {
+		return d.err
+	}
+	if err := d.write(recDelete, key, nil); err != nil {
+		return err
+	}
+	d.m.Delete(key)
+	d.maybeSnapshot()
+	return nil
+}
+
+// write appends a record to the log.
+func (d *DurableMap[K, V]) write(op byte, key K, value *V) error {
+	if d.err != nil {
+		return d.err
+	}
+	var err error
+	buf := append(d.buf[:0], make([]byte, recHeaderSize)...)
+	buf = append(buf, op)
+	if buf, err = d.opts.KeyCodec.Append(buf, key); err != nil {
+		return err
+	}
+	if value != nil {
+		if buf, err = d.opts.ValueCodec.Append(buf, *value); err != nil {
+			return err
+		}
+	}
+	rec := buf[recHeaderSize:]
+	binary.LittleEndian.PutUint32(buf, uint32(len(rec)))
+	binary.LittleEndian.PutUint32(buf[4:], crc32.Checksum(rec, crcTable))
+	d.buf = buf
+	if _, err := d.log.Write(buf); err != nil {
+		// The log might end with part of the record, so no more records
+		// can be written.
+		d.err = err
+		return err
+	}
+	d.records++
+	switch d.opts.Sync {
+	case SyncAlways:
+		return d.sync()
+	case SyncInterval:
+		if time.Since(d.lastSync) >= d.opts.SyncInterval {
+			return d.sync()
+		}
+	}
+	return nil
+}
+
+func (d *DurableMap[K, V]) sync() error {
+	if err := d.log.Sync(); err != nil {
+		d.err = err
+		return err
+	}
+	d.lastSync = time.Now()
+	return nil
+}
+
+// Sync flushes the log to stable storage.
+func (d *DurableMap[K, V]) Sync() error {
+	if d.err != nil {
+		return d.err
+	}
+	return d.sync()
+}
+
+// maybeSnapshot writes a snapshot when the log has enough records. A
+// snapshot that fails is not tried again until another SnapshotEvery
+// records are written, so that every write does not wait for it.
+func (d *DurableMap[K, V]) maybeSnapshot() {
+	if d.records < d.snapAt {
+		return
+	}
+	d.snapErr = d.Snapshot()
+	if d.snapErr != nil {
+		d.snapAt = d.records + d.opts.SnapshotEvery
+	}
+}
+
+// SnapshotErr returns the error of the last automatic snapshot, or nil when
+// it succeeded. The writes before a failed snapshot are still in the log.
+func (d *DurableMap[K, V]) SnapshotErr() error {
+	return d.snapErr
+}
+
+// Snapshot writes the map to a new snapshot and clears the log.
+func (d *DurableMap[K, V]) Snapshot() error {
+	if d.err != nil {
+		return d.err
+	}
+	data, err := d.m.MarshalBinaryWith(d.opts.KeyCodec, d.opts.ValueCodec)
+	if err != nil {
+		return err
+	}
+	data = binary.LittleEndian.AppendUint32(data,
+		crc32.Checksum(data, crcTable))
+	// The new snapshot replaces the old one atomically. A crash before the
+	// log is cleared replays the log on top of the new snapshot, which has
+	// the same result.
+	name := filepath.Join(d.dir, durableSnapshot)
+	if err := writeFileSync(name+".tmp", data); err != nil {
+		return err
+	}
+	if err := os.Rename(name+".tmp", name); err != nil {
+		return err
+	}
+	syncDir(d.dir)
+	if err := d.log.Truncate(0); err != nil {
+		d.err = err
+		return err
+	}
+	if _, err := d.log.Seek(0, 0); err != nil {
+		d.err = err
+		return err
+	}
+	d.records = 0
+	d.snapAt = d.opts.SnapshotEvery
+	d.snapErr = nil
+	return d.sync()
+}
+
+// Close syncs and closes the log.
+func (d *DurableMap[K, V]) Close() error {
+	if d.log == nil {
+		return nil
+	}
+	err := d.log.Sync()
+	if err2 := d.log.Close(); err == nil {
+		err = err2
+	}
+	d.log = nil
+	if d.err == nil {
+		d.err = os.ErrClosed
+	}
+	return err
+}
+
+func writeFileSync(name string, data []byte) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// syncDir flushes a directory, which makes a rename durable. Errors are
+// ignored, because some systems do not support syncing directories.
+func syncDir(dir string) {
+	if f, err := os.Open(dir); err == nil {
+		f.Sync()
+		f.Close()
+	}
+}
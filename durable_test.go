@@ -0,0 +1,196 @@
+// Copyright 2019 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an ISC-style
+// license that can be found in the LICENSE file.
+
+package hashmap
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func checkDurable(t *testing.T, d *DurableMap[string, int], want map[string]int) {
+	t.Helper()
+	if d.Len() != len(want) {
+		t.Fatalf("expected %v, got %v", len(want), d.Len())
+	}
+	for key, value := range want {
+		if v, ok := d.Get(key); !ok || v != value {
+			t.Fatalf("key %v: expected %v, got %v", key, value, v)
+		}
+	}
+}
+
+func TestDurable(t *testing.T) {
+	dir := t.TempDir()
+	for _, policy := range []SyncPolicy{SyncAlways, SyncInterval, SyncNever} {
+		opts := &DurableOptions[string, int]{Sync: policy, SnapshotEvery: 300}
+		d, err := OpenDurable(dir, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := make(map[string]int)
+		d.Scan(func(key string, value int) bool {
+			want[key] = value
+			return true
+		})
+		for i := 0; i < 1000; i++ {
+			key := k(i % 400)
+			if i%3 == 0 {
+				if err := d.Delete(key); err != nil {
+					t.Fatal(err)
+				}
+				delete(want, key)
+			} else {
+				if err := d.Set(key, i); err != nil {
+					t.Fatal(err)
+				}
+				want[key] = i
+			}
+		}
+		checkDurable(t, d, want)
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Set("a", 1); err == nil {
+			t.Fatal("expected error")
+		}
+		d, err = OpenDurable(dir, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkDurable(t, d, want)
+		d.Close()
+	}
+}
+
+func TestDurableTruncatedLog(t *testing.T) {
+	dir := t.TempDir()
+	d, err := OpenDurable[string, int](dir,
+		&DurableOptions[string, int]{Sync: SyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Record the size of the log after each write.
+	logName := filepath.Join(dir, durableLog)
+	var sizes []int64
+	for i := 0; i < 20; i++ {
+		d.Set(k(i), i)
+		fi, _ := os.Stat(logName)
+		sizes = append(sizes, fi.Size())
+	}
+	d.Close()
+	full, _ := os.ReadFile(logName)
+	for cut := int64(0); cut <= int64(len(full)); cut++ {
+		os.WriteFile(logName, full[:cut], 0666)
+		// the writes that were fully logged
+		want := make(map[string]int)
+		for i := 0; i < 20 && sizes[i] <= cut; i++ {
+			want[k(i)] = i
+		}
+		d, err := OpenDurable[string, int](dir, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkDurable(t, d, want)
+		// new records follow the last valid one
+		if err := d.Set("new", -1); err != nil {
+			t.Fatal(err)
+		}
+		d.Close()
+		d, err = OpenDurable[string, int](dir, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want["new"] = -1
+		checkDurable(t, d, want)
+		d.Close()
+	}
+
+	// a corrupt record ends the log
+	full[sizes[9]+recHeaderSize+1] ^= 0xFF
+	os.WriteFile(logName, full, 0666)
+	d, err = OpenDurable[string, int](dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Len() != 10 {
+		t.Fatalf("expected %v, got %v", 10, d.Len())
+	}
+	d.Close()
+}
+
+func TestDurableSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	d, err := OpenDurable[string, int](dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		d.Set(k(i), i)
+	}
+	if err := d.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if fi, _ := os.Stat(filepath.Join(dir, durableLog)); fi.Size() != 0 {
+		t.Fatalf("expected %v, got %v", 0, fi.Size())
+	}
+	d.Delete(k(0))
+	d.Close()
+	d, err = OpenDurable[string, int](dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.Get(k(0)); ok || d.Len() != 99 {
+		t.Fatalf("expected %v, got %v", 99, d.Len())
+	}
+	d.Close()
+	// a corrupt snapshot is an error
+	name := filepath.Join(dir, durableSnapshot)
+	data, _ := os.ReadFile(name)
+	data[len(data)/2] ^= 0xFF
+	os.WriteFile(name, data, 0666)
+	if _, err := OpenDurable[string, int](dir, nil); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestDurableSnapshotFailed(t *testing.T) {
+	dir := t.TempDir()
+	d, err := OpenDurable(dir, &DurableOptions[string, int]{SnapshotEvery: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	// A directory in place of the temporary snapshot file fails snapshots.
+	tmp := filepath.Join(dir, durableSnapshot+".tmp")
+	if err := os.Mkdir(tmp, 0777); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 15; i++ {
+		if err := d.Set(k(i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d.SnapshotErr() == nil {
+		t.Fatal("expected error")
+	}
+	// The snapshot is not tried again until another SnapshotEvery records.
+	os.Remove(tmp)
+	for i := 15; i < 19; i++ {
+		d.Set(k(i), i)
+		if d.records != i+1 || d.SnapshotErr() == nil {
+			t.Fatalf("expected %v, got %v", i+1, d.records)
+		}
+	}
+	d.Delete(k(0))
+	if err := d.SnapshotErr(); err != nil || d.records != 0 {
+		t.Fatalf("expected %v, got %v", nil, err)
+	}
+	want := make(map[string]int)
+	for i := 1; i < 19; i++ {
+		want[k(i)] = i
+	}
+	checkDurable(t, d, want)
+}
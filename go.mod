@@ -1,6 +1,6 @@
 module github.com/tidwall/hashmap
 
-go 1.18
+go 1.23
 
 require github.com/zeebo/xxh3 v1.0.2
 
@@ -0,0 +1,195 @@
+// Copyright 2019 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an ISC-style
+// license that can be found in the LICENSE file.
+
+package hashmap
+
+import (
+	"math"
+	"reflect"
+	"sync"
+	"unsafe"
+
+	"github.com/zeebo/xxh3"
+)
+
+// Keys that are not a string, and that are not a flat block of memory, such
+// as structs with string or interface fields, floats, or structs with
+// padding, are hashed by walking a list of ops. The ops are generated once
+// per key type using reflection and describe where each piece of the key
+// lives in memory and how it should be hashed.
+
+type keyOpKind uint8
+
+const (
+	opBytes   keyOpKind = iota // raw memory
+	opString                   // string contents
+	opFloat32                  // float32, with -0 normalized to +0
+	opFloat64                  // float64, with -0 normalized to +0
+	opIface                    // interface, hashed by its dynamic value
+)
+
+type keyOp struct {
+	kind keyOpKind
+	off  uintptr      // offset of the field from the start of the key
+	size uintptr      // size of raw memory, opBytes only
+	typ  reflect.Type // interface type, opIface only
+}
+
+// keyLayouts caches the key ops for each key type.
+var keyLayouts sync.Map // map[reflect.Type][]keyOp
+
+// keyLayout returns the ops needed for hashing a key of type t.
+func keyLayout(t reflect.Type) []keyOp {
+	if ops, ok := keyLayouts.Load(t); ok {
+		return ops.([]keyOp)
+	}
+	ops := appendKeyOps(nil, t, 0)
+	keyLayouts.Store(t, ops)
+	return ops
+}
+
+func appendKeyOps(ops []keyOp, t reflect.Type, off uintptr) []keyOp {
+	switch t.Kind() {
+	case reflect.String:
+		return append(ops, keyOp{kind: opString, off: off})
+	case reflect.Float32:
+		return append(ops, keyOp{kind: opFloat32, off: off})
+	case reflect.Float64:
+		return append(ops, keyOp{kind: opFloat64, off: off})
+	case reflect.Complex64:
+		ops = append(ops, keyOp{kind: opFloat32, off: off})
+		return append(ops, keyOp{kind: opFloat32, off: off + 4})
+	case reflect.Complex128:
+		ops = append(ops, keyOp{kind: opFloat64, off: off})
+		return append(ops, keyOp{kind: opFloat64, off: off + 8})
+	case reflect.Interface:
+		return append(ops, keyOp{kind: opIface, off: off, typ: t})
+	case reflect.Array:
+		esize := t.Elem().Size()
+		for i := 0; i < t.Len(); i++ {
+			ops = appendKeyOps(ops, t.Elem(), off+uintptr(i)*esize)
+		}
+		return ops
+	case reflect.Struct:
+		// Only named fields take part in comparisons. The padding between
+		// fields, and blank fields, are skipped.
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.Name != "_" {
+				ops = appendKeyOps(ops, f.Type, off+f.Offset)
+			}
+		}
+		return ops
+	}
+	// Everything else is compared by its memory, such as bools, integers,
+	// pointers, and channels. Adjacent memory is merged into one op.
+	if t.Size() == 0 {
+		return ops
+	}
+	if len(ops) > 0 {
+		last := &ops[len(ops)-1]
+		if last.kind == opBytes && last.off+last.size == off {
+			last.size += t.Size()
+			return ops
+		}
+	}
+	return append(ops, keyOp{kind: opBytes, off: off, size: t.Size()})
+}
+
+// hashKey hashes the key at p using the provided ops.
+func hashKey(p unsafe.Pointer, ops []keyOp, seed uint64) uint64 {
+	h := seed
+	for i := range ops {
+		ptr := unsafe.Add(p, ops[i].off)
+		switch ops[i].kind {
+		case opBytes:
+			h = xxh3.HashSeed(unsafe.Slice((*byte)(ptr), ops[i].size), h)
+		case opString:
+			h = xxh3.HashStringSeed(*(*string)(ptr), h)
+		case opFloat32:
+			h = hashFloat(float64(*(*float32)(ptr)), h)
+		case opFloat64:
+			h = hashFloat(*(*float64)(ptr), h)
+		case opIface:
+			h = hashValue(ifaceValue(ops[i].typ, ptr), h)
+		}
+	}
+	return h
+}
+
+func hashUint64(x uint64, seed uint64) uint64 {
+	var b [8]byte
+	for i := 0; i < 8; i++ {
+		b[i] = byte(x >> (i * 8))
+	}
+	return xxh3.HashSeed(b[:], seed)
+}
+
+func hashFloat(f float64, seed uint64) uint64 {
+	if f == 0 {
+		f = 0 // -0 == +0
+	}
+	return hashUint64(math.Float64bits(f), seed)
+}
+
+// ifaceValue returns the interface of type t at p as a reflect.Value.
+// The interface is copied so that p does not escape.
+func ifaceValue(t reflect.Type, p unsafe.Pointer) reflect.Value {
+	if t.NumMethod() == 0 {
+		return reflect.ValueOf(*(*interface{})(p))
+	}
+	iface := new([2]unsafe.Pointer)
+	*iface = *(*[2]unsafe.Pointer)(p)
+	return reflect.NewAt(t, unsafe.Pointer(iface)).Elem().Elem()
+}
+
+// hashValue hashes the dynamic value of an interface. This is slower than
+// hashKey but works for any comparable type.
+func hashValue(v reflect.Value, seed uint64) uint64 {
+	if !v.IsValid() {
+		// nil interface
+		return hashUint64(0, seed)
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return xxh3.HashStringSeed(v.String(), seed)
+	case reflect.Bool:
+		if v.Bool() {
+			return hashUint64(1, seed)
+		}
+		return hashUint64(0, seed)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		return hashUint64(uint64(v.Int()), seed)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		return hashUint64(v.Uint(), seed)
+	case reflect.Float32, reflect.Float64:
+		return hashFloat(v.Float(), seed)
+	case reflect.Complex64, reflect.Complex128:
+		c := v.Complex()
+		return hashFloat(imag(c), hashFloat(real(c), seed))
+	case reflect.Pointer, reflect.Chan, reflect.UnsafePointer:
+		return hashUint64(uint64(v.Pointer()), seed)
+	case reflect.Interface:
+		return hashValue(v.Elem(), seed)
+	case reflect.Array:
+		h := seed
+		for i := 0; i < v.Len(); i++ {
+			h = hashValue(v.Index(i), h)
+		}
+		return h
+	case reflect.Struct:
+		h := seed
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			if t.Field(i).Name != "_" {
+				h = hashValue(v.Field(i), h)
+			}
+		}
+		return h
+	}
+	// Same as the Go runtime when using a slice, map, or func as a key.
+	panic("hashmap: hash of unhashable type " + v.Type().String())
+}
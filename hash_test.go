@@ -0,0 +1,146 @@
+// Copyright 2019 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an ISC-style
+// license that can be found in the LICENSE file.
+
+package hashmap
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+type personKey struct {
+	Name string
+	ID   int
+}
+
+func TestHashStructStringKeys(t *testing.T) {
+	m := New[personKey, int](0)
+	for i := 0; i < 1000; i++ {
+		m.Set(personKey{fmt.Sprintf("name:%d", i), i}, i)
+	}
+	for i := 0; i < 1000; i++ {
+		// build the name from a different allocation
+		name := strings.Repeat("x", 0) + "name:" + fmt.Sprint(i)
+		key := personKey{name, i}
+		v, ok := m.Get(key)
+		if !ok || v != i {
+			t.Fatalf("expected %v, got %v", i, v)
+		}
+	}
+	if _, ok := m.Get(personKey{"name:1", 2}); ok {
+		t.Fatal("expected false")
+	}
+}
+
+type paddedKey struct {
+	A byte
+	B int64
+	C bool
+	_ int32
+	D int16
+}
+
+func TestHashPadding(t *testing.T) {
+	m := New[paddedKey, int](0)
+	var k1, k2 paddedKey
+	// scribble over the padding and blank fields of k2
+	b := unsafe.Slice((*byte)(unsafe.Pointer(&k2)), unsafe.Sizeof(k2))
+	for i := range b {
+		b[i] = 0xFF
+	}
+	k1 = paddedKey{A: 1, B: 2, C: true, D: 3}
+	k2.A, k2.B, k2.C, k2.D = 1, 2, true, 3
+	if k1 != k2 {
+		t.Fatal("expected equal keys")
+	}
+	if m.hash(k1) != m.hash(k2) {
+		t.Fatal("expected equal hashes")
+	}
+}
+
+func TestHashInterfaceKeys(t *testing.T) {
+	m := New[interface{}, int](0)
+	for i := 0; i < 100; i++ {
+		m.Set(fmt.Sprintf("key:%d", i), i)
+		m.Set(i, i)
+		m.Set(personKey{fmt.Sprint(i), i}, i)
+	}
+	if m.Len() != 300 {
+		t.Fatalf("expected %v, got %v", 300, m.Len())
+	}
+	for i := 0; i < 100; i++ {
+		for _, key := range []interface{}{
+			"key:" + fmt.Sprint(i), i, personKey{fmt.Sprint(i), i},
+		} {
+			v, ok := m.Get(key)
+			if !ok || v != i {
+				t.Fatalf("expected %v, got %v", i, v)
+			}
+		}
+	}
+	m.Set(nil, -1)
+	if v, ok := m.Get(nil); !ok || v != -1 {
+		t.Fatalf("expected %v, got %v", -1, v)
+	}
+}
+
+type stringer interface{ String() string }
+
+type strT string
+
+func (s strT) String() string { return string(s) }
+
+type ifaceKey struct {
+	S stringer
+	A [2]string
+	F float64
+}
+
+func TestHashNestedKeys(t *testing.T) {
+	m := New[ifaceKey, int](0)
+	k1 := ifaceKey{strT("hello" + fmt.Sprint(1)), [2]string{"a", "b"}, 0}
+	k2 := ifaceKey{strT(fmt.Sprintf("hello%d", 1)),
+		[2]string{strings.ToLower("A"), "b"}, math.Copysign(0, -1)}
+	if k1 != k2 {
+		t.Fatal("expected equal keys")
+	}
+	if m.hash(k1) != m.hash(k2) {
+		t.Fatal("expected equal hashes")
+	}
+	m.Set(k1, 1)
+	if v, ok := m.Get(k2); !ok || v != 1 {
+		t.Fatalf("expected %v, got %v", 1, v)
+	}
+	k3 := k2
+	k3.A[1] = "c"
+	if _, ok := m.Get(k3); ok {
+		t.Fatal("expected false")
+	}
+}
+
+func TestHashFloatKeys(t *testing.T) {
+	m := New[float64, int](0)
+	m.Set(0, 1)
+	if v, ok := m.Get(math.Copysign(0, -1)); !ok || v != 1 {
+		t.Fatalf("expected %v, got %v", 1, v)
+	}
+	m.Set(math.NaN(), 2)
+	m.Set(math.NaN(), 3)
+	if m.Len() != 3 {
+		t.Fatalf("expected %v, got %v", 3, m.Len())
+	}
+}
+
+func TestHashUnhashable(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic")
+		}
+	}()
+	m := New[interface{}, int](0)
+	m.Set([]int{1}, 1)
+}
@@ -0,0 +1,482 @@
+// Copyright 2019 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an ISC-style
+// license that can be found in the LICENSE file.
+
+package hashmap
+
+import (
+	"iter"
+	"math/bits"
+)
+
+// ImmutableMap is a persistent hashmap. Set and Delete do not modify the map,
+// but instead return a new map that shares most of its memory with the old
+// one, which makes it cheap to keep old versions around.
+//
+// It's a hash array mapped trie over the same hashes as Map. Each node
+// branches on 5 bits of the hash, and keys whose hashes are fully equal are
+// kept together in a collision node at the bottom of the trie.
+//
+// The zero value is an empty map ready to use. Maps are safe for concurrent
+// use by multiple goroutines.
+type ImmutableMap[K comparable, V any] struct {
+	cfg    *Map[K, V] // hashing config shared by all versions, has no buckets
+	root   *immNode[K, V]
+	length int
+}
+
+const (
+	immBits = 5
+	immMask = 1<<immBits - 1
+)
+
+type immNode[K comparable, V any] struct {
+	edit    *immEdit // the builder that is allowed to modify the node
+	datamap uint32   // slots that are entries
+	nodemap uint32   // slots that are child nodes
+	entries []entry[K, V]
+	nodes   []*immNode[K, V]
+}
+
+// immEdit identifies an ImmutableBuilder. Nodes created by a builder can be
+// modified in place by the same builder until its map is returned.
+type immEdit struct{ _ byte }
+
+// NewImmutableMap returns a new empty ImmutableMap.
+func NewImmutableMap[K comparable, V any]() *ImmutableMap[K, V] {
+	return &ImmutableMap[K, V]{cfg: newImmConfig[K, V]()}
+}
+
+func newImmConfig[K comparable, V any]() *Map[K, V] {
+	cfg := new(Map[K, V])
+	cfg.seed = newSeed()
+	cfg.detectHasher()
+	return cfg
+}
+
+// config returns the hashing config, which is created for the zero value.
+func (im *ImmutableMap[K, V]) config() *Map[K, V] {
+	if im.cfg == nil {
+		return newImmConfig[K, V]()
+	}
+	return im.cfg
+}
+
+// Len returns the number of values in map.
+func (im *ImmutableMap[K, V]) Len() int {
+	return im.length
+}
+
+// Get returns a value for a key.
+// Returns false when no value has been assign for key.
+func (im *ImmutableMap[K, V]) Get(key K) (value V, ok bool) {
+	if im.root == nil {
+		return value, false
+	}
+	return im.root.get(im.cfg.hash(key), key)
+}
+
+// Set returns a new map with the value assigned to the key.
+func (im *ImmutableMap[K, V]) Set(key K, value V) *ImmutableMap[K, V] {
+	cfg := im.config()
+	root, _, replaced := im.root.set(0, cfg.hash(key), key, value, nil)
+	im2 := &ImmutableMap[K, V]{cfg: cfg, root: root, length: im.length}
+	if !replaced {
+		im2.length++
+	}
+	return im2
+}
+
+// Delete returns a new map without the key. The same map is returned when
+// the key does not exist.
+func (im *ImmutableMap[K, V]) Delete(key K) *ImmutableMap[K, V] {
+	if im.root == nil {
+		return im
+	}
+	root, _, deleted := im.root.delete(0, im.cfg.hash(key), key, nil)
+	if !deleted {
+		return im
+	}
+	return &ImmutableMap[K, V]{cfg: im.cfg, root: root, length: im.length - 1}
+}
+
+// Scan iterates over all key/values.
+func (im *ImmutableMap[K, V]) Scan(iter func(key K, value V) bool) {
+	if im.root != nil {
+		im.root.scan(iter)
+	}
+}
+
+// All returns an iterator over all key/values.
+func (im *ImmutableMap[K, V]) All() iter.Seq2[K, V] {
+	return im.Scan
+}
+
+// Keys returns all keys as a slice
+func (im *ImmutableMap[K, V]) Keys() []K {
+	keys := make([]K, 0, im.length)
+	im.Scan(func(key K, _ V) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+// Values returns all values as a slice
+func (im *ImmutableMap[K, V]) Values() []V {
+	values := make([]V, 0, im.length)
+	im.Scan(func(_ K, value V) bool {
+		values = append(values, value)
+		return true
+	})
+	return values
+}
+
+// ToMap returns a new Map with all of the key/values.
+// The keys are not rehashed.
+func (im *ImmutableMap[K, V]) ToMap() *Map[K, V] {
+	cfg := im.config()
+	m := new(Map[K, V])
+	m.hasher = cfg.hasher
+	m.ksize = cfg.ksize
+	m.kstr = cfg.kstr
+	m.kops = cfg.kops
+	m.seed = cfg.seed
+	m.opts = cfg.opts
+	m.alloc(m.sizeFor(im.length))
+	if im.root != nil {
+		im.root.each(func(e *entry[K, V]) {
+			m.insert(e.hash()&m.mask, 1, *e)
+		})
+	}
+	m.length = im.length
+	return m
+}
+
+// ToImmutable returns a new ImmutableMap with all of the key/values.
+// The keys are not rehashed.
+func (m *Map[K, V]) ToImmutable() *ImmutableMap[K, V] {
+	im := new(ImmutableMap[K, V])
+	im.cfg = &Map[K, V]{
+		hasher: m.hasher,
+		ksize:  m.ksize,
+		kstr:   m.kstr,
+		kops:   m.kops,
+		seed:   m.seed,
+		opts:   m.opts,
+	}
+	if len(m.buckets) == 0 {
+		im.cfg.seed = newSeed()
+		im.cfg.detectHasher()
+		return im
+	}
+	b := im.Builder()
+	for _, buckets := range m.tables() {
+		for i := range buckets {
+			if buckets[i].dib() > 0 {
+				b.set(buckets[i].hash(), buckets[i].key, buckets[i].value)
+			}
+		}
+	}
+	return b.Map()
+}
+
+// ImmutableBuilder builds an ImmutableMap by modifying its own nodes in
+// place, which is much faster than using ImmutableMap.Set for many changes.
+// It's not safe for concurrent use.
+type ImmutableBuilder[K comparable, V any] struct {
+	cfg    *Map[K, V]
+	root   *immNode[K, V]
+	length int
+	edit   *immEdit
+}
+
+// Builder returns a builder that starts with the key/values of the map.
+// The map itself is not modified.
+func (im *ImmutableMap[K, V]) Builder() *ImmutableBuilder[K, V] {
+	return &ImmutableBuilder[K, V]{
+		cfg:    im.config(),
+		root:   im.root,
+		length: im.length,
+		edit:   new(immEdit),
+	}
+}
+
+// Len returns the number of values in the builder.
+func (b *ImmutableBuilder[K, V]) Len() int {
+	return b.length
+}
+
+// Get returns a value for a key.
+// Returns false when no value has been assign for key.
+func (b *ImmutableBuilder[K, V]) Get(key K) (value V, ok bool) {
+	if b.root == nil {
+		return value, false
+	}
+	return b.root.get(b.cfg.hash(key), key)
+}
+
+// Set assigns a value to a key.
+// Returns the previous value, or false when no value was assigned.
+func (b *ImmutableBuilder[K, V]) Set(key K, value V) (V, bool) {
+	return b.set(b.cfg.hash(key), key, value)
+}
+
+func (b *ImmutableBuilder[K, V]) set(hash int, key K, value V) (V, bool) {
+	var prev V
+	var replaced bool
+	b.root, prev, replaced = b.root.set(0, hash, key, value, b.edit)
+	if !replaced {
+		b.length++
+	}
+	return prev, replaced
+}
+
+// Delete deletes a value for a key.
+// Returns the deleted value, or false when no value was assigned.
+func (b *ImmutableBuilder[K, V]) Delete(key K) (prev V, deleted bool) {
+	if b.root == nil {
+		return prev, false
+	}
+	b.root, prev, deleted = b.root.delete(0, b.cfg.hash(key), key, b.edit)
+	if deleted {
+		b.length--
+	}
+	return prev, deleted
+}
+
+// Map returns an ImmutableMap with the key/values of the builder.
+// The builder can continue to be used afterwards without affecting the
+// returned map.
+func (b *ImmutableBuilder[K, V]) Map() *ImmutableMap[K, V] {
+	// The nodes now belong to the map.
+	b.edit = new(immEdit)
+	return &ImmutableMap[K, V]{cfg: b.cfg, root: b.root, length: b.length}
+}
+
+// immSlot returns the bit for the hash at a level of the trie, and the
+// position of the bit within a bitmap.
+func immSlot(bitmap uint32, hash int, shift uint) (bit uint32, pos int) {
+	bit = 1 << (uint(hash) >> shift & immMask)
+	return bit, bits.OnesCount32(bitmap & (bit - 1))
+}
+
+// clone returns a node that can be modified by edit, which is the node
+// itself when it's already owned by edit.
+func (n *immNode[K, V]) clone(edit *immEdit) *immNode[K, V] {
+	if edit != nil && n.edit == edit {
+		return n
+	}
+	n2 := &immNode[K, V]{edit: edit, datamap: n.datamap, nodemap: n.nodemap}
+	n2.entries = append([]entry[K, V](nil), n.entries...)
+	n2.nodes = append([]*immNode[K, V](nil), n.nodes...)
+	return n2
+}
+
+func (n *immNode[K, V]) get(hash int, key K) (value V, ok bool) {
+	for shift := uint(0); ; shift += immBits {
+		if shift >= hashBitSize {
+			// collision node
+			for i := range n.entries {
+				if n.entries[i].key == key {
+					return n.entries[i].value, true
+				}
+			}
+			return value, false
+		}
+		bit, _ := immSlot(0, hash, shift)
+		if n.datamap&bit != 0 {
+			_, i := immSlot(n.datamap, hash, shift)
+			if n.entries[i].hash() == hash && n.entries[i].key == key {
+				return n.entries[i].value, true
+			}
+			return value, false
+		}
+		if n.nodemap&bit == 0 {
+			return value, false
+		}
+		_, i := immSlot(n.nodemap, hash, shift)
+		n = n.nodes[i]
+	}
+}
+
+// set returns the node with the value assigned to the key, and the
+// previous value when it was replaced.
+func (n *immNode[K, V]) set(shift uint, hash int, key K, value V,
+	edit *immEdit,
+) (_ *immNode[K, V], prev V, replaced bool) {
+	e := entry[K, V]{makeHDIB(hash, 1), value, key}
+	if n == nil {
+		n = &immNode[K, V]{edit: edit}
+	}
+	if shift >= hashBitSize {
+		// collision node
+		for i := range n.entries {
+			if n.entries[i].key == key {
+				n = n.clone(edit)
+				prev = n.entries[i].value
+				n.entries[i].value = value
+				return n, prev, true
+			}
+		}
+		n = n.clone(edit)
+		n.entries = append(n.entries, e)
+		return n, prev, false
+	}
+	bit, _ := immSlot(0, hash, shift)
+	switch {
+	case n.datamap&bit != 0:
+		_, i := immSlot(n.datamap, hash, shift)
+		if n.entries[i].hash() == hash && n.entries[i].key == key {
+			n = n.clone(edit)
+			prev = n.entries[i].value
+			n.entries[i].value = value
+			return n, prev, true
+		}
+		// Move both entries into a new child node.
+		child := immMerge(shift+immBits, n.entries[i], e, edit)
+		n = n.clone(edit)
+		n.entries = deleteAt(n.entries, i)
+		n.datamap ^= bit
+		n.nodemap |= bit
+		_, j := immSlot(n.nodemap, hash, shift)
+		n.nodes = insertAt(n.nodes, j, child)
+		return n, prev, false
+	case n.nodemap&bit != 0:
+		_, i := immSlot(n.nodemap, hash, shift)
+		var child *immNode[K, V]
+		child, prev, replaced = n.nodes[i].set(shift+immBits, hash, key, value,
+			edit)
+		n = n.clone(edit)
+		n.nodes[i] = child
+		return n, prev, replaced
+	default:
+		_, i := immSlot(n.datamap, hash, shift)
+		n = n.clone(edit)
+		n.entries = insertAt(n.entries, i, e)
+		n.datamap |= bit
+		return n, prev, false
+	}
+}
+
+// immMerge returns a new node with two entries that have different keys.
+func immMerge[K comparable, V any](shift uint, e1, e2 entry[K, V],
+	edit *immEdit,
+) *immNode[K, V] {
+	n := &immNode[K, V]{edit: edit}
+	if shift >= hashBitSize {
+		n.entries = []entry[K, V]{e1, e2}
+		return n
+	}
+	bit1, _ := immSlot(0, e1.hash(), shift)
+	bit2, _ := immSlot(0, e2.hash(), shift)
+	switch {
+	case bit1 == bit2:
+		n.nodemap = bit1
+		n.nodes = []*immNode[K, V]{immMerge(shift+immBits, e1, e2, edit)}
+	case bit1 < bit2:
+		n.datamap = bit1 | bit2
+		n.entries = []entry[K, V]{e1, e2}
+	default:
+		n.datamap = bit1 | bit2
+		n.entries = []entry[K, V]{e2, e1}
+	}
+	return n
+}
+
+// delete returns the node without the key, and the deleted value.
+// Returns nil when the node is empty.
+func (n *immNode[K, V]) delete(shift uint, hash int, key K, edit *immEdit,
+) (_ *immNode[K, V], prev V, deleted bool) {
+	if shift >= hashBitSize {
+		// collision node
+		for i := range n.entries {
+			if n.entries[i].key == key {
+				prev = n.entries[i].value
+				n = n.clone(edit)
+				n.entries = deleteAt(n.entries, i)
+				return n, prev, true
+			}
+		}
+		return n, prev, false
+	}
+	bit, _ := immSlot(0, hash, shift)
+	switch {
+	case n.datamap&bit != 0:
+		_, i := immSlot(n.datamap, hash, shift)
+		if n.entries[i].hash() != hash || n.entries[i].key != key {
+			return n, prev, false
+		}
+		prev = n.entries[i].value
+		if len(n.entries) == 1 && len(n.nodes) == 0 {
+			return nil, prev, true
+		}
+		n = n.clone(edit)
+		n.entries = deleteAt(n.entries, i)
+		n.datamap ^= bit
+		return n, prev, true
+	case n.nodemap&bit != 0:
+		_, i := immSlot(n.nodemap, hash, shift)
+		var child *immNode[K, V]
+		child, prev, deleted = n.nodes[i].delete(shift+immBits, hash, key, edit)
+		if !deleted {
+			return n, prev, false
+		}
+		n = n.clone(edit)
+		if child == nil {
+			n.nodes = deleteAt(n.nodes, i)
+			n.nodemap ^= bit
+		} else if len(child.entries) == 1 && len(child.nodes) == 0 {
+			// A child with a single entry is replaced by the entry, which
+			// keeps the trie as shallow as possible.
+			n.nodes = deleteAt(n.nodes, i)
+			n.nodemap ^= bit
+			n.datamap |= bit
+			_, j := immSlot(n.datamap, hash, shift)
+			n.entries = insertAt(n.entries, j, child.entries[0])
+		} else {
+			n.nodes[i] = child
+		}
+		return n, prev, true
+	default:
+		return n, prev, false
+	}
+}
+
+func (n *immNode[K, V]) scan(iter func(key K, value V) bool) bool {
+	for i := range n.entries {
+		if !iter(n.entries[i].key, n.entries[i].value) {
+			return false
+		}
+	}
+	for _, child := range n.nodes {
+		if !child.scan(iter) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n *immNode[K, V]) each(fn func(e *entry[K, V])) {
+	for i := range n.entries {
+		fn(&n.entries[i])
+	}
+	for _, child := range n.nodes {
+		child.each(fn)
+	}
+}
+
+func insertAt[T any](s []T, i int, v T) []T {
+	var zero T
+	s = append(s, zero)
+	copy(s[i+1:], s[i:])
+	s[i] = v
+	return s
+}
+
+func deleteAt[T any](s []T, i int) []T {
+	copy(s[i:], s[i+1:])
+	var zero T
+	s[len(s)-1] = zero
+	return s[:len(s)-1]
+}
@@ -0,0 +1,154 @@
+// Copyright 2019 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an ISC-style
+// license that can be found in the LICENSE file.
+
+package hashmap
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func checkImmutable(t *testing.T, im *ImmutableMap[int, int], want map[int]int) {
+	t.Helper()
+	if im.Len() != len(want) {
+		t.Fatalf("expected %v, got %v", len(want), im.Len())
+	}
+	for key, value := range want {
+		if v, ok := im.Get(key); !ok || v != value {
+			t.Fatalf("key %v: expected %v, got %v", key, value, v)
+		}
+	}
+	var n int
+	for key, value := range im.All() {
+		if want[key] != value {
+			t.Fatalf("key %v: expected %v, got %v", key, want[key], value)
+		}
+		n++
+	}
+	if n != len(want) {
+		t.Fatalf("expected %v, got %v", len(want), n)
+	}
+}
+
+func testImmutable(t *testing.T, im *ImmutableMap[int, int], keys int) {
+	// Keep every version and check them all at the end.
+	var versions []*ImmutableMap[int, int]
+	var wants []map[int]int
+	want := make(map[int]int)
+	for i := 0; i < 5000; i++ {
+		key := rand.Intn(keys)
+		if rand.Intn(3) == 0 {
+			im = im.Delete(key)
+			delete(want, key)
+		} else {
+			im = im.Set(key, i)
+			want[key] = i
+		}
+		if i%100 == 0 {
+			versions = append(versions, im)
+			w := make(map[int]int, len(want))
+			for k, v := range want {
+				w[k] = v
+			}
+			wants = append(wants, w)
+		}
+	}
+	for i := range versions {
+		checkImmutable(t, versions[i], wants[i])
+	}
+	checkImmutable(t, im, want)
+	for key := range want {
+		im = im.Delete(key)
+	}
+	if im.Len() != 0 || im.root != nil {
+		t.Fatalf("expected empty")
+	}
+}
+
+func TestImmutable(t *testing.T) {
+	var im ImmutableMap[int, int]
+	if _, ok := im.Get(1); ok {
+		t.Fatal("expected false")
+	}
+	if im.Delete(1) != &im {
+		t.Fatal("expected same map")
+	}
+	testImmutable(t, &im, 1000)
+	testImmutable(t, NewImmutableMap[int, int](), 1<<30)
+	// every key collides
+	m := NewWithHasher[int, int](0, func(key int) uint64 {
+		return uint64(key % 3)
+	})
+	testImmutable(t, m.ToImmutable(), 100)
+}
+
+func TestImmutableBuilder(t *testing.T) {
+	im := NewImmutableMap[int, int]()
+	b := im.Builder()
+	for i := 0; i < 1000; i++ {
+		b.Set(i, i)
+	}
+	im1 := b.Map()
+	for i := 0; i < 1000; i += 2 {
+		if v, ok := b.Delete(i); !ok || v != i {
+			t.Fatalf("expected %v, got %v", i, v)
+		}
+	}
+	b.Set(1, -1)
+	im2 := b.Map()
+	if im.Len() != 0 {
+		t.Fatalf("expected %v, got %v", 0, im.Len())
+	}
+	want := make(map[int]int)
+	for i := 0; i < 1000; i++ {
+		want[i] = i
+	}
+	checkImmutable(t, im1, want)
+	for i := 0; i < 1000; i += 2 {
+		delete(want, i)
+	}
+	want[1] = -1
+	checkImmutable(t, im2, want)
+	if v, ok := b.Get(1); !ok || v != -1 || b.Len() != 500 {
+		t.Fatalf("expected %v, got %v", -1, v)
+	}
+}
+
+func TestImmutableConvert(t *testing.T) {
+	m := New[string, int](0)
+	for i := 0; i < 1000; i++ {
+		m.Set(k(i), i)
+	}
+	im := m.ToImmutable()
+	im = im.Set("hello", -1)
+	m2 := im.ToMap()
+	if m2.Len() != 1001 {
+		t.Fatalf("expected %v, got %v", 1001, m2.Len())
+	}
+	for i := 0; i < 1000; i++ {
+		if v, ok := m2.Get(k(i)); !ok || v != i {
+			t.Fatalf("expected %v, got %v", i, v)
+		}
+	}
+	if v, _ := m2.Get("hello"); v != -1 {
+		t.Fatalf("expected %v, got %v", -1, v)
+	}
+	keys := im.Keys()
+	sort.Strings(keys)
+	if len(keys) != 1001 || len(im.Values()) != 1001 {
+		t.Fatalf("expected %v, got %v", 1001, len(keys))
+	}
+	if _, ok := m.Get("hello"); ok {
+		t.Fatal("expected false")
+	}
+	var empty Map[string, int]
+	im = empty.ToImmutable().Set("a", 1)
+	if v, ok := im.Get("a"); !ok || v != 1 {
+		t.Fatalf("expected %v, got %v", 1, v)
+	}
+	if im.ToMap().Len() != 1 {
+		t.Fatalf("expected %v, got %v", 1, im.ToMap().Len())
+	}
+}
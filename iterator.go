@@ -0,0 +1,161 @@
+// Copyright 2019 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an ISC-style
+// license that can be found in the LICENSE file.
+
+package hashmap
+
+import "errors"
+
+// ErrModified is returned by Iterator.Err when the map was changed by
+// something other than the iterator while iterating.
+var ErrModified = errors.New("hashmap: map modified during iteration")
+
+// Iterator iterates over a map, and can change the value of or delete the
+// current entry while iterating. Each entry is visited once.
+//
+//	it := m.Iterator()
+//	for it.Next() {
+//		if expired(it.Value()) {
+//			it.Delete()
+//		}
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+//
+// Adding or deleting keys with the map while iterating, or anything that
+// resizes the map, stops the iteration with ErrModified. Setting the value
+// of a key that is in the map is allowed, but SetValue is faster for the
+// current entry.
+type Iterator[K comparable, V any] struct {
+	m      *Map[K, V]
+	gen    uint64 // the gen of the map, which changes when it's modified
+	start  int    // position of an empty bucket
+	next   int    // distance from start of the next bucket to visit
+	i      int    // position of the current entry, or -1 when there's none
+	shrink bool   // shrink the map when done, because entries were deleted
+	err    error
+}
+
+// Iterator returns an iterator over all key/values. Call Next to move to the
+// first entry.
+func (m *Map[K, V]) Iterator() *Iterator[K, V] {
+	if m.old != nil {
+		// Finish the incremental resize, so that the entries are in one
+		// bucket array.
+		m.own()
+		m.moveAll()
+	}
+	it := &Iterator[K, V]{m: m, gen: m.gen, next: 1, i: -1}
+	if m.length == 0 {
+		it.next = len(m.buckets) + 1
+		return it
+	}
+	// Start after an empty bucket. Deleting an entry shifts the following
+	// entries back, which never moves an entry past an empty bucket.
+	for m.buckets[it.start].dib() != 0 {
+		it.start++
+	}
+	return it
+}
+
+// Next moves to the next entry.
+// Returns false when there are no more entries, or when the map was
+// modified, which is reported by Err.
+func (it *Iterator[K, V]) Next() bool {
+	it.i = -1
+	if !it.valid() {
+		return false
+	}
+	m := it.m
+	for it.next <= len(m.buckets) {
+		i := (it.start + it.next) & m.mask
+		it.next++
+		if m.buckets[i].dib() > 0 {
+			it.i = i
+			return true
+		}
+	}
+	if it.shrink {
+		it.shrink = false
+		m.maybeShrink()
+		it.gen = m.gen
+	}
+	return false
+}
+
+// Err returns ErrModified when the map was modified while iterating.
+func (it *Iterator[K, V]) Err() error {
+	return it.err
+}
+
+// valid returns false when the map was modified by something other than
+// the iterator, which stops the iteration with ErrModified.
+func (it *Iterator[K, V]) valid() bool {
+	if it.err == nil && it.m.gen != it.gen {
+		it.err = ErrModified
+		it.i = -1
+	}
+	return it.err == nil
+}
+
+// current returns the current entry, or nil when the map was modified.
+func (it *Iterator[K, V]) current() *entry[K, V] {
+	if !it.valid() {
+		return nil
+	}
+	if it.i == -1 {
+		panic("hashmap: iterator has no current entry")
+	}
+	return &it.m.buckets[it.i]
+}
+
+// Key returns the key of the current entry, or the zero value when the map
+// was modified.
+// Panics when there's no current entry.
+func (it *Iterator[K, V]) Key() (key K) {
+	if e := it.current(); e != nil {
+		key = e.key
+	}
+	return key
+}
+
+// Value returns the value of the current entry, or the zero value when the
+// map was modified.
+// Panics when there's no current entry.
+func (it *Iterator[K, V]) Value() (value V) {
+	if e := it.current(); e != nil {
+		value = e.value
+	}
+	return value
+}
+
+// SetValue changes the value of the current entry. Nothing is changed when
+// the map was modified.
+// Panics when there's no current entry.
+func (it *Iterator[K, V]) SetValue(value V) {
+	if it.current() == nil {
+		return
+	}
+	it.m.own()
+	it.m.buckets[it.i].value = value
+}
+
+// Delete deletes the current entry. There's no current entry until the next
+// call to Next. The map is shrunk, when needed, after the last entry has
+// been visited. Nothing is deleted when the map was modified.
+// Panics when there's no current entry.
+func (it *Iterator[K, V]) Delete() {
+	if it.current() == nil {
+		return
+	}
+	m := it.m
+	m.own()
+	m.eraseAt(it.i)
+	m.length--
+	it.gen = m.gen
+	// The following entry was shifted into this bucket, so visit it again.
+	it.next--
+	it.i = -1
+	it.shrink = true
+}
@@ -0,0 +1,193 @@
+// Copyright 2019 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an ISC-style
+// license that can be found in the LICENSE file.
+
+package hashmap
+
+import "testing"
+
+func TestIterator(t *testing.T) {
+	for _, opts := range []*Options{nil, {Incremental: true}} {
+		m := NewWithOptions[int, int](0, opts)
+		for i := 0; i < 10000; i++ {
+			m.Set(i, i)
+		}
+		m2 := m.Copy()
+		visited := make(map[int]int)
+		it := m.Iterator()
+		for it.Next() {
+			visited[it.Key()]++
+			if it.Key() != it.Value() {
+				t.Fatalf("expected %v, got %v", it.Key(), it.Value())
+			}
+			if it.Key()%10 == 0 {
+				it.SetValue(-it.Key())
+			} else {
+				it.Delete()
+			}
+		}
+		if err := it.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if len(visited) != 10000 {
+			t.Fatalf("expected %v, got %v", 10000, len(visited))
+		}
+		for key, n := range visited {
+			if n != 1 {
+				t.Fatalf("key %v: expected %v, got %v", key, 1, n)
+			}
+		}
+		if m.Len() != 1000 || len(m.buckets) > 2048 {
+			t.Fatalf("expected %v, got %v", 1000, m.Len())
+		}
+		for i := 0; i < 10000; i++ {
+			v, ok := m.Get(i)
+			if ok != (i%10 == 0) || v != -i && ok {
+				t.Fatalf("key %v: expected %v, got %v", i, -i, v)
+			}
+		}
+		if m.old != nil {
+			m.moveAll()
+		}
+		checkDIBs(t, m)
+		if m2.Len() != 10000 {
+			t.Fatalf("expected %v, got %v", 10000, m2.Len())
+		}
+		if v, _ := m2.Get(10); v != 10 {
+			t.Fatalf("expected %v, got %v", 10, v)
+		}
+	}
+	// Entries that wrap around the end of the buckets.
+	m := NewWithHasher[int, int](0, func(key int) uint64 {
+		return uint64(14-key%4) << dibBitSize
+	})
+	for i := 0; i < 12; i++ {
+		m.Set(i, i)
+	}
+	if len(m.buckets) != 16 {
+		t.Fatalf("expected %v, got %v", 16, len(m.buckets))
+	}
+	visited := make(map[int]int)
+	for it := m.Iterator(); it.Next(); {
+		visited[it.Key()]++
+		if it.Key()%2 == 1 {
+			it.Delete()
+		}
+	}
+	for i := 0; i < 12; i++ {
+		if visited[i] != 1 {
+			t.Fatalf("key %v: expected %v, got %v", i, 1, visited[i])
+		}
+		if _, ok := m.Get(i); ok != (i%2 == 0) {
+			t.Fatalf("key %v: expected %v, got %v", i, i%2 == 0, ok)
+		}
+	}
+	checkDIBs(t, m)
+	var empty Map[int, int]
+	it := empty.Iterator()
+	if it.Next() || it.Err() != nil {
+		t.Fatal("expected false")
+	}
+}
+
+func TestIteratorModified(t *testing.T) {
+	m := New[int, int](0)
+	for i := 0; i < 100; i++ {
+		m.Set(i, i)
+	}
+	for _, modify := range []func(){
+		func() { m.Set(1000, 1000) },
+		func() { m.Delete(1000) },
+		func() { m.Reserve(1000) },
+		func() { m.ShrinkToFit() },
+		func() { m.Clear() },
+		func() {
+			// The same length, but the entries may have moved.
+			m.Delete(50)
+			m.Set(1001, 1001)
+		},
+	} {
+		it := m.Iterator()
+		if !it.Next() {
+			t.Fatal("expected true")
+		}
+		m.Set(it.Key(), 1) // setting a key in the map is allowed
+		if !it.Next() {
+			t.Fatal("expected true")
+		}
+		modify()
+		if it.Next() || it.Err() != ErrModified {
+			t.Fatalf("expected %v, got %v", ErrModified, it.Err())
+		}
+		if it.Next() {
+			t.Fatal("expected false")
+		}
+		for i := 0; i < 100; i++ {
+			m.Set(i, i)
+		}
+	}
+	// The current entry is not used after the map is modified.
+	m = New[int, int](0)
+	for i := 0; i < 6; i++ {
+		m.Set(i, i)
+	}
+	it := m.Iterator()
+	it.Next()
+	for i := 100; i < 200; i++ {
+		m.Set(i, i)
+	}
+	if key := it.Key(); key != 0 || it.Err() != ErrModified {
+		t.Fatalf("expected %v, got %v", ErrModified, it.Err())
+	}
+	it.SetValue(-1)
+	it.Delete()
+	var n int
+	m.Scan(func(key, value int) bool {
+		if value != key {
+			t.Fatalf("expected %v, got %v", key, value)
+		}
+		n++
+		return true
+	})
+	if n != 106 || m.Len() != 106 {
+		t.Fatalf("expected %v, got %v/%v", 106, n, m.Len())
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expected panic")
+			}
+		}()
+		it := m.Iterator()
+		it.Next()
+		it.Delete()
+		it.Key()
+	}()
+}
+
+func TestIteratorSetExisting(t *testing.T) {
+	for _, opts := range []*Options{nil, {Incremental: true}} {
+		m := NewWithOptions[int, int](0, opts)
+		// Exactly at the point where the next new key grows the map.
+		for i := 0; m.Len() < m.growAt; i++ {
+			m.Set(i, i)
+		}
+		it := m.Iterator()
+		var n int
+		for it.Next() {
+			m.Set(it.Key(), -it.Key())
+			n++
+		}
+		if err := it.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if n != m.Len() {
+			t.Fatalf("expected %v, got %v", m.Len(), n)
+		}
+		for i := 0; i < n; i++ {
+			if v, _ := m.Get(i); v != -i {
+				t.Fatalf("expected %v, got %v", -i, v)
+			}
+		}
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2019 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an ISC-style
+// license that can be found in the LICENSE file.
+
+package hashmap
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// jsonObjectKey returns true when a key type is encoded as the name of a
+// JSON object, following the rules that encoding/json uses for Go maps.
+func jsonObjectKey(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Int, reflect.Int8, reflect.Int16,
+		reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8,
+		reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return t.Implements(textMarshalerType)
+}
+
+// jsonKeyName returns the name for a key in a JSON object.
+func jsonKeyName(k reflect.Value) (string, error) {
+	if k.Kind() == reflect.String {
+		return k.String(), nil
+	}
+	if tm, ok := k.Interface().(encoding.TextMarshaler); ok {
+		if k.Kind() == reflect.Pointer && k.IsNil() {
+			return "", nil
+		}
+		b, err := tm.MarshalText()
+		return string(b), err
+	}
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), nil
+	default:
+		return strconv.FormatUint(k.Uint(), 10), nil
+	}
+}
+
+// SetSortedJSON sets whether MarshalJSON sorts the keys, which makes the
+// output deterministic at some cost to performance.
+func (m *Map[K, V]) SetSortedJSON(sorted bool) {
+	m.sortJSON = sorted
+}
+
+// jsonItem is an encoded key/value. The key is the object name or encoded
+// key, which is also used for sorting.
+type jsonItem struct {
+	key   string
+	value []byte
+}
+
+// MarshalJSON encodes the map as a JSON object when the keys are strings,
+// integers, or implement encoding.TextMarshaler, like encoding/json does for
+// Go maps. Otherwise the map is encoded as an array of [key, value] pairs.
+// It has a value receiver so that a Map that is not a pointer, such as a
+// field of a struct, is also encoded.
+func (m Map[K, V]) MarshalJSON() ([]byte, error) {
+	object := jsonObjectKey(reflect.TypeOf((*K)(nil)).Elem())
+	items := make([]jsonItem, 0, m.length)
+	var err error
+	m.Scan(func(key K, value V) bool {
+		var item jsonItem
+		if object {
+			item.key, err = jsonKeyName(reflect.ValueOf(&key).Elem())
+		} else {
+			var b []byte
+			b, err = json.Marshal(key)
+			item.key = string(b)
+		}
+		if err == nil {
+			item.value, err = json.Marshal(value)
+		}
+		items = append(items, item)
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if m.sortJSON {
+		sort.Slice(items, func(i, j int) bool {
+			return items[i].key < items[j].key
+		})
+	}
+	var buf bytes.Buffer
+	if object {
+		buf.WriteByte('{')
+	} else {
+		buf.WriteByte('[')
+	}
+	for i, item := range items {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if object {
+			name, _ := json.Marshal(item.key)
+			buf.Write(name)
+			buf.WriteByte(':')
+		} else {
+			buf.WriteByte('[')
+			buf.WriteString(item.key)
+			buf.WriteByte(',')
+		}
+		buf.Write(item.value)
+		if !object {
+			buf.WriteByte(']')
+		}
+	}
+	if object {
+		buf.WriteByte('}')
+	} else {
+		buf.WriteByte(']')
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON decodes the map from a JSON object or an array of
+// [key, value] pairs, replacing all of its keys and values.
+// A JSON null leaves the map unchanged, like encoding/json does.
+func (m *Map[K, V]) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if string(data) == "null" {
+		return nil
+	}
+	if len(data) > 0 && data[0] == '{' {
+		var obj map[K]V
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return err
+		}
+		m.Clear()
+		m.Reserve(len(obj))
+		for key, value := range obj {
+			m.Set(key, value)
+		}
+		return nil
+	}
+	var pairs [][2]json.RawMessage
+	if err := json.Unmarshal(data, &pairs); err != nil {
+		return err
+	}
+	m.Clear()
+	m.Reserve(len(pairs))
+	for _, pair := range pairs {
+		var key K
+		var value V
+		if err := json.Unmarshal(pair[0], &key); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(pair[1], &value); err != nil {
+			return err
+		}
+		m.Set(key, value)
+	}
+	return nil
+}
+
+// SetSortedJSON sets whether MarshalJSON sorts the keys, which makes the
+// output deterministic at some cost to performance.
+func (tr *Set[K]) SetSortedJSON(sorted bool) {
+	tr.base.sortJSON = sorted
+}
+
+// MarshalJSON encodes the set as a JSON array of keys.
+// It has a value receiver so that a Set that is not a pointer, such as a
+// field of a struct, is also encoded.
+func (tr Set[K]) MarshalJSON() ([]byte, error) {
+	keys := make([][]byte, 0, tr.base.length)
+	var err error
+	tr.base.Scan(func(key K, _ struct{}) bool {
+		var b []byte
+		b, err = json.Marshal(key)
+		keys = append(keys, b)
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if tr.base.sortJSON {
+		sort.Slice(keys, func(i, j int) bool {
+			return bytes.Compare(keys[i], keys[j]) < 0
+		})
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	buf.Write(bytes.Join(keys, []byte{','}))
+	buf.WriteByte(']')
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON decodes the set from a JSON array of keys, replacing all
+// of its keys.
+// A JSON null leaves the set unchanged, like encoding/json does.
+func (tr *Set[K]) UnmarshalJSON(data []byte) error {
+	if string(bytes.TrimSpace(data)) == "null" {
+		return nil
+	}
+	var keys []K
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+	tr.base.Clear()
+	tr.base.Reserve(len(keys))
+	for _, key := range keys {
+		tr.Insert(key)
+	}
+	return nil
+}
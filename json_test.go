@@ -0,0 +1,92 @@
+// Copyright 2019 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an ISC-style
+// license that can be found in the LICENSE file.
+
+package hashmap
+
+import (
+	"encoding/json"
+	"net/netip"
+	"testing"
+)
+
+func TestJSON(t *testing.T) {
+	var resp struct {
+		Names Map[string, int]
+		IDs   Map[int, []string]
+		Addrs Map[netip.Addr, bool]
+		Pts   Map[point, string]
+		Tags  Set[string]
+	}
+	resp.Names.SetSortedJSON(true)
+	resp.IDs.SetSortedJSON(true)
+	resp.Addrs.SetSortedJSON(true)
+	resp.Pts.SetSortedJSON(true)
+	resp.Tags.SetSortedJSON(true)
+	resp.Names.Set("b", 2)
+	resp.Names.Set("a", 1)
+	resp.Names.Set("<c>", 3)
+	resp.IDs.Set(-1, []string{"x"})
+	resp.IDs.Set(10, nil)
+	resp.Addrs.Set(netip.MustParseAddr("10.0.0.1"), true)
+	resp.Pts.Set(point{1, 2, 3}, "p")
+	resp.Tags.Insert("z")
+	resp.Tags.Insert("y")
+	data, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := `{"Names":{"\u003cc\u003e":3,"a":1,"b":2},` +
+		`"IDs":{"-1":["x"],"10":null},"Addrs":{"10.0.0.1":true},` +
+		`"Pts":[[{"X":1,"Y":2,"Z":3},"p"]],"Tags":["y","z"]}`
+	if string(data) != exp {
+		t.Fatalf("expected\n%s\ngot\n%s", exp, data)
+	}
+	var resp2 struct {
+		Names *Map[string, int]
+		IDs   Map[int, []string]
+		Addrs Map[netip.Addr, bool]
+		Pts   Map[point, string]
+		Tags  *Set[string]
+	}
+	// existing keys are replaced
+	resp2.IDs.Set(5, nil)
+	if err := json.Unmarshal(data, &resp2); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := resp2.Names.Get("<c>"); v != 3 || resp2.Names.Len() != 3 {
+		t.Fatalf("expected %v, got %v", 3, v)
+	}
+	if v, _ := resp2.IDs.Get(-1); len(v) != 1 || v[0] != "x" ||
+		resp2.IDs.Len() != 2 {
+		t.Fatalf("expected %v, got %v", []string{"x"}, v)
+	}
+	if v, _ := resp2.Addrs.Get(netip.MustParseAddr("10.0.0.1")); !v {
+		t.Fatal("expected true")
+	}
+	if v, _ := resp2.Pts.Get(point{1, 2, 3}); v != "p" {
+		t.Fatalf("expected %v, got %v", "p", v)
+	}
+	if !resp2.Tags.Contains("y") || resp2.Tags.Len() != 2 {
+		t.Fatal("expected true")
+	}
+	// null leaves the maps and sets unchanged
+	err = json.Unmarshal([]byte(`{"IDs":null,"Addrs":null}`), &resp2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp2.IDs.Len() != 2 || resp2.Addrs.Len() != 1 {
+		t.Fatalf("expected %v, got %v", 2, resp2.IDs.Len())
+	}
+	if err := resp2.IDs.UnmarshalJSON([]byte(" null ")); err != nil ||
+		resp2.IDs.Len() != 2 {
+		t.Fatalf("expected %v, got %v", 2, resp2.IDs.Len())
+	}
+	if err := resp2.Tags.UnmarshalJSON([]byte("null")); err != nil ||
+		resp2.Tags.Len() != 2 {
+		t.Fatalf("expected %v, got %v", 2, resp2.Tags.Len())
+	}
+	if err := json.Unmarshal([]byte(`{"Pts":{"a":"b"}}`), &resp2); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -5,6 +5,13 @@
 package hashmap
 
 import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"iter"
+	"math/bits"
+	"math/rand"
+	"reflect"
+	"sync/atomic"
 	"unsafe"
 
 	"github.com/zeebo/xxh3"
@@ -18,7 +25,7 @@ const (
 	maxDIB      = ^uint64(0) >> hashBitSize // max 65,535
 )
 
-type entry[K comparable, V any] struct {
+type entry[K any, V any] struct {
 	hdib  uint64 // bitfield { hash:48 dib:16 }
 	value V      // user value
 	key   K      // user key
@@ -31,18 +38,45 @@ func (e *entry[K, V]) hash() int {
 	return int(e.hdib >> dibBitSize)
 }
 func (e *entry[K, V]) setDIB(dib int) {
-	e.hdib = e.hdib>>dibBitSize<<dibBitSize | uint64(dib)&maxDIB
+	e.hdib = e.hdib>>dibBitSize<<dibBitSize | clampDIB(dib)
 }
 func (e *entry[K, V]) setHash(hash int) {
 	e.hdib = uint64(hash)<<dibBitSize | e.hdib&maxDIB
 }
 func makeHDIB(hash, dib int) uint64 {
-	return uint64(hash)<<dibBitSize | uint64(dib)&maxDIB
+	return uint64(hash)<<dibBitSize | clampDIB(dib)
+}
+func clampDIB(dib int) uint64 {
+	if uint64(dib) > maxDIB {
+		return maxDIB
+	}
+	return uint64(dib)
+}
+
+// dibAt returns the DIB of the entry at position i.
+// A DIB that does not fit in the entry is stored as maxDIB, and the actual
+// DIB is then calculated from the position of the entry and its hash. This
+// allows for very long probe sequences, such as those caused by a poor
+// hasher, without wrapping the DIB to zero and making the entry look empty.
+func dibAt[K any, V any](buckets []entry[K, V], mask int, i int) int {
+	dib := buckets[i].dib()
+	if uint64(dib) == maxDIB {
+		dib = (i-buckets[i].hash())&mask + 1
+	}
+	return dib
 }
 
 // hash returns a 48-bit hash for 64-bit environments, or 32-bit hash for
 // 32-bit environments.
 func (m *Map[K, V]) hash(key K) int {
+	if m.hasher != nil {
+		return int(m.hasher(key) >> dibBitSize)
+	}
+	if m.kops != nil {
+		// Keys with strings, interfaces, floats, or padding are hashed
+		// field by field.
+		return int(hashKey(unsafe.Pointer(&key), m.kops, m.seed) >> dibBitSize)
+	}
 	// The unsafe package is used here to cast the key into a string container
 	// so that the hasher can work. The hasher normally only accept a string or
 	// []byte, but this effectively allows it to accept value type.
@@ -60,7 +94,28 @@ func (m *Map[K, V]) hash(key K) int {
 		}{unsafe.Pointer(&key), m.ksize}))
 	}
 	// Now for the actual hashing.
-	return int(xxh3.HashString(strKey) >> dibBitSize)
+	return int(xxh3.HashStringSeed(strKey, m.seed) >> dibBitSize)
+}
+
+var (
+	seedBase    uint64 // random, set at startup
+	seedCounter uint64 // incremented for each new seed
+)
+
+func init() {
+	var b [8]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	seedBase = binary.LittleEndian.Uint64(b[:])
+}
+
+// newSeed returns a random seed for a new map. Each map has its own seed so
+// that an attacker cannot craft keys that collide.
+func newSeed() uint64 {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], atomic.AddUint64(&seedCounter, 1))
+	return xxh3.HashSeed(b[:], seedBase)
 }
 
 // Map is a hashmap. Like map[string]interface{}
@@ -73,11 +128,59 @@ type Map[K comparable, V any] struct {
 	buckets  []entry[K, V]
 	ksize    int
 	kstr     bool
+	kops     []keyOp
+	hasher   func(key K) uint64
+	seed     uint64
+	opts     *Options // nil for defaultOptions
+	sortJSON bool
+
+	// The bucket arrays may be shared with copies of the map. The number of
+	// maps sharing them is in refs, and they are cloned by the first write
+	// when shared.
+	refs *atomic.Int32
+
+	// gen is changed whenever entries are added, removed, or moved to
+	// other buckets, which is detected by an Iterator.
+	gen uint64
+
+	// Incremental resizing. Entries that have not been moved to the new
+	// buckets yet are in old. Buckets are moved in order, starting at
+	// oldStart, which was an empty bucket.
+	old      []entry[K, V]
+	oldMask  int
+	oldStart int // position of the first bucket to be moved
+	oldPos   int // position of the next bucket to be moved
+	oldLeft  int // number of buckets left to move
 }
 
 // New returns a new Map. Like map[string]interface{}
 func New[K comparable, V any](cap int) *Map[K, V] {
 	m := new(Map[K, V])
+	m.init(cap)
+	return m
+}
+
+// NewWithHasher returns a new Map that uses the provided hash function for
+// hashing keys, instead of the default xxh3 hasher.
+// Keys that are equal must always produce the same hash.
+func NewWithHasher[K comparable, V any](cap int, hash func(key K) uint64,
+) *Map[K, V] {
+	m := new(Map[K, V])
+	m.hasher = hash
+	m.init(cap)
+	return m
+}
+
+// NewWithSeed returns a new Map that uses the provided seed for hashing
+// keys, instead of a random one. This is useful for reproducible tests, but
+// keys may be vulnerable to hash-flooding when the seed is known.
+func NewWithSeed[K comparable, V any](cap int, seed uint64) *Map[K, V] {
+	m := New[K, V](cap)
+	m.seed = seed
+	return m
+}
+
+func (m *Map[K, V]) init(cap int) {
 	m.cap = cap
 	sz := 8
 	for sz < m.cap {
@@ -86,69 +189,429 @@ func New[K comparable, V any](cap int) *Map[K, V] {
 	if m.cap > 0 {
 		m.cap = sz
 	}
-	m.buckets = make([]entry[K, V], sz)
-	m.mask = len(m.buckets) - 1
-	m.growAt = int(float64(len(m.buckets)) * loadFactor)
-	m.shrinkAt = int(float64(len(m.buckets)) * (1 - loadFactor))
+	m.alloc(sz)
+	m.seed = newSeed()
 	m.detectHasher()
-	return m
+}
+
+// alloc allocates an empty bucket array. The size must be a power of two.
+func (m *Map[K, V]) alloc(sz int) {
+	m.setBuckets(make([]entry[K, V], sz))
+}
+
+// setBuckets replaces the bucket array, which must be owned by the map.
+// The length is set to zero.
+func (m *Map[K, V]) setBuckets(buckets []entry[K, V]) {
+	m.buckets = buckets
+	m.refs = new(atomic.Int32)
+	m.refs.Store(1)
+	m.gen++
+	m.mask = len(m.buckets) - 1
+	opts := m.options()
+	m.growAt = int(float64(len(m.buckets)) * opts.MaxLoadFactor)
+	m.shrinkAt = int(float64(len(m.buckets)) * opts.MinLoadFactor)
+	if opts.NoShrink {
+		m.shrinkAt = -1
+	}
+	m.length = 0
+}
+
+func (m *Map[K, V]) options() *Options {
+	if m.opts == nil {
+		return &defaultOptions
+	}
+	return m.opts
 }
 
 func (m *Map[K, V]) detectHasher() {
+	if m.hasher != nil {
+		// User provided hasher
+		return
+	}
 	// Detect the key type. This is needed by the hasher.
 	var k K
 	switch ((interface{})(k)).(type) {
 	case string:
 		m.kstr = true
-	default:
-		m.ksize = int(unsafe.Sizeof(k))
+		return
+	}
+	m.ksize = int(unsafe.Sizeof(k))
+	// Keys that are not a flat block of memory, such as a struct with a
+	// string field, need to be hashed field by field.
+	ops := keyLayout(reflect.TypeOf((*K)(nil)).Elem())
+	if len(ops) != 1 || ops[0].kind != opBytes ||
+		ops[0].size != uintptr(m.ksize) {
+		m.kops = ops
 	}
 }
 
 func (m *Map[K, V]) resize(newCap int) {
-	nmap := New[K, V](newCap)
-	for i := 0; i < len(m.buckets); i++ {
-		if m.buckets[i].dib() > 0 {
-			nmap.set(m.buckets[i].hash(), m.buckets[i].key, m.buckets[i].value)
+	sz := 8
+	for sz < newCap {
+		sz *= 2
+	}
+	incremental := m.options().Incremental
+	if m.old != nil || incremental {
+		// The old entries are modified while moving.
+		m.own()
+	}
+	if m.old != nil {
+		// Already resizing
+		m.moveAll()
+	}
+	buckets := m.buckets
+	refs := m.refs
+	length := m.length
+	m.alloc(sz)
+	m.length = length
+	if incremental {
+		m.old = buckets
+		m.oldMask = len(buckets) - 1
+		m.oldStart = 0
+		for m.old[m.oldStart].dib() > 0 {
+			m.oldStart++
 		}
+		m.oldPos = m.oldStart
+		m.oldLeft = len(buckets)
+		return
+	}
+	for i := 0; i < len(buckets); i++ {
+		if buckets[i].dib() > 0 {
+			m.insert(buckets[i].hash()&m.mask, 1, buckets[i])
+		}
+	}
+	refs.Add(-1)
+}
+
+// own is called before modifying the map. The bucket arrays are cloned
+// when they are shared with a copy of the map.
+func (m *Map[K, V]) own() {
+	if m.refs == nil || m.refs.Load() == 1 {
+		return
+	}
+	buckets := make([]entry[K, V], len(m.buckets))
+	copy(buckets, m.buckets)
+	m.buckets = buckets
+	if m.old != nil {
+		old := make([]entry[K, V], len(m.old))
+		copy(old, m.old)
+		m.old = old
+	}
+	m.refs.Add(-1)
+	m.refs = new(atomic.Int32)
+	m.refs.Store(1)
+}
+
+// resizeStep is the minimum number of buckets moved by each Set, Get, and
+// Delete while incrementally resizing.
+const resizeStep = 16
+
+// moveStep returns the number of buckets to move for each Set, Get, and
+// Delete while incrementally resizing. It's more than resizeStep when the
+// map could be resized again before all of the old buckets are moved,
+// which would then have to move the rest of them at once.
+func (m *Map[K, V]) moveStep() int {
+	headroom := m.growAt - m.length
+	if m.shrinkAt >= 0 && len(m.buckets) > m.cap {
+		headroom = min(headroom, m.length-m.shrinkAt)
+	}
+	headroom = max(headroom, 1)
+	return max(resizeStep, (m.oldLeft+headroom-1)/headroom)
+}
+
+// move moves up to n buckets from old to the new buckets.
+func (m *Map[K, V]) move(n int) {
+	for ; n > 0 && m.oldLeft > 0; n-- {
+		i := m.oldPos
+		if m.old[i].dib() > 0 {
+			m.insert(m.old[i].hash()&m.mask, 1, m.old[i])
+			m.old[i] = entry[K, V]{}
+		}
+		m.oldPos = (m.oldPos + 1) & m.oldMask
+		m.oldLeft--
+	}
+	if m.oldLeft == 0 {
+		m.old = nil
+	}
+}
+
+// moveAll finishes an incremental resize.
+func (m *Map[K, V]) moveAll() {
+	m.move(m.oldLeft)
+}
+
+// findOld returns the position of a key that has not been moved yet, or -1
+// if the key is not in old.
+func (m *Map[K, V]) findOld(hash int, key K) int {
+	i := hash & m.oldMask
+	if (i-m.oldStart)&m.oldMask < len(m.old)-m.oldLeft {
+		// The initial bucket has been moved, but the key might have been
+		// further along, which has not been moved yet. The buckets from the
+		// initial bucket to the key were all occupied, so the search can
+		// continue from the next bucket to be moved.
+		i = m.oldPos
+	}
+	for {
+		if m.old[i].dib() == 0 {
+			return -1
+		}
+		if m.old[i].hash() == hash && m.old[i].key == key {
+			return i
+		}
+		i = (i + 1) & m.oldMask
+	}
+}
+
+// settle is called before accessing a key in the buckets while resizing
+// incrementally. A few buckets are moved, and the key itself is moved from
+// old to the new buckets.
+func (m *Map[K, V]) settle(hash int, key K) {
+	m.move(m.moveStep())
+	if m.old == nil {
+		return
+	}
+	if i := m.findOld(hash, key); i != -1 {
+		e := m.old[i]
+		erase(m.old, m.oldMask, i)
+		m.insert(e.hash()&m.mask, 1, e)
+	}
+}
+
+// sizeFor returns the smallest number of buckets that can hold n entries
+// without growing.
+func (m *Map[K, V]) sizeFor(n int) int {
+	return bucketsFor(n, m.cap, m.options().MaxLoadFactor)
+}
+
+// bucketsFor returns the smallest number of buckets, and no less than min,
+// that can hold n entries without growing.
+func bucketsFor(n, min int, maxLoadFactor float64) int {
+	sz := 8
+	for sz < min || int(float64(sz)*maxLoadFactor) < n {
+		sz *= 2
+	}
+	return sz
+}
+
+// Clear removes all keys and values.
+// The bucket array is retained so it can be reused without allocating. Call
+// ShrinkToFit afterwards to release its memory.
+func (m *Map[K, V]) Clear() {
+	if m.refs != nil && m.refs.Load() > 1 {
+		// Shared with a copy, so there's nothing to clone.
+		m.refs.Add(-1)
+		m.old = nil
+		m.alloc(len(m.buckets))
+	}
+	clear(m.buckets)
+	m.old = nil
+	m.length = 0
+	m.gen++
+}
+
+// Reserve grows the map, when needed, so that it can hold at least n more
+// values without resizing. Useful before bulk loading.
+func (m *Map[K, V]) Reserve(n int) {
+	if len(m.buckets) == 0 {
+		m.init(0)
+	}
+	if sz := m.sizeFor(m.length + n); sz > len(m.buckets) {
+		m.resize(sz)
+	}
+}
+
+// ShrinkToFit shrinks the map to the smallest size that holds its values,
+// but no smaller than the capacity provided to New.
+func (m *Map[K, V]) ShrinkToFit() {
+	if sz := m.sizeFor(m.length); sz < len(m.buckets) {
+		m.resize(sz)
 	}
-	cap := m.cap
-	*m = *nmap
-	m.cap = cap
 }
 
 // Set assigns a value to a key.
 // Returns the previous value, or false when no value was assigned.
 func (m *Map[K, V]) Set(key K, value V) (V, bool) {
 	if len(m.buckets) == 0 {
-		*m = *New[K, V](0)
+		m.init(0)
 	}
-	if m.length >= m.growAt {
-		m.resize(len(m.buckets) * 2)
+	return m.setHashed(m.hash(key), key, value)
+}
+
+// setHashed is Set for a key that has already been hashed.
+func (m *Map[K, V]) setHashed(hash int, key K, value V) (V, bool) {
+	m.own()
+	if m.old != nil {
+		m.settle(hash, key)
 	}
-	return m.set(m.hash(key), key, value)
+	return m.set(hash, key, value)
 }
 
+// set assigns a value to a key. The map is only grown when the key is new,
+// so replacing a value never moves other entries.
 func (m *Map[K, V]) set(hash int, key K, value V) (prev V, ok bool) {
-	e := entry[K, V]{makeHDIB(hash, 1), value, key}
-	i := e.hash() & m.mask
+	i, dib, found := m.find(hash, key)
+	if found {
+		prev = m.buckets[i].value
+		m.buckets[i].value = value
+		return prev, true
+	}
+	m.add(i, dib, hash, key, value)
+	return prev, false
+}
+
+// find returns the position of the key. When the key is not found, the
+// position and DIB where the key belongs is returned instead.
+func (m *Map[K, V]) find(hash int, key K) (i, dib int, found bool) {
+	return findEntry(m.buckets, m.mask, hash, key, equal[K])
+}
+
+// insert places a new entry at position i, which must have come from find,
+// or be the initial bucket of a key that is known to not exist.
+func (m *Map[K, V]) insert(i, dib int, e entry[K, V]) {
+	insertEntry(m.buckets, m.mask, i, dib, e)
+	m.gen++
+}
+
+// eraseAt removes the entry at position i, without changing the length.
+func (m *Map[K, V]) eraseAt(i int) {
+	erase(m.buckets, m.mask, i)
+	m.gen++
+}
+
+func equal[K comparable](a, b K) bool {
+	return a == b
+}
+
+// findEntry is find for the bucket array of a Map or MapFunc, using the
+// provided equal function for keys.
+func findEntry[K any, V any](buckets []entry[K, V], mask int, hash int, key K,
+	equal func(a, b K) bool,
+) (i, dib int, found bool) {
+	i = hash & mask
+	dib = 1
 	for {
-		if m.buckets[i].dib() == 0 {
-			m.buckets[i] = e
-			m.length++
-			return prev, false
+		if buckets[i].dib() == 0 {
+			return i, dib, false
 		}
-		if e.hash() == m.buckets[i].hash() && e.key == m.buckets[i].key {
-			prev = m.buckets[i].value
-			m.buckets[i].value = e.value
-			return prev, true
+		if buckets[i].hash() == hash && equal(buckets[i].key, key) {
+			return i, dib, true
 		}
-		if m.buckets[i].dib() < e.dib() {
-			e, m.buckets[i] = m.buckets[i], e
+		if dibAt(buckets, mask, i) < dib {
+			return i, dib, false
 		}
-		i = (i + 1) & m.mask
-		e.setDIB(e.dib() + 1)
+		i = (i + 1) & mask
+		dib++
+	}
+}
+
+// insertEntry is insert for the bucket array of a Map or MapFunc.
+// Entries with a smaller DIB are shifted down, Robin Hood style.
+func insertEntry[K any, V any](buckets []entry[K, V], mask int, i, dib int,
+	e entry[K, V],
+) {
+	for {
+		if buckets[i].dib() == 0 {
+			e.setDIB(dib)
+			buckets[i] = e
+			return
+		}
+		if bdib := dibAt(buckets, mask, i); bdib < dib {
+			e.setDIB(dib)
+			e, buckets[i] = buckets[i], e
+			dib = bdib
+		}
+		i = (i + 1) & mask
+		dib++
+	}
+}
+
+// add adds a new entry for a key that was not found at position i.
+// The map is grown first when needed.
+// Returns the position of the new entry.
+func (m *Map[K, V]) add(i, dib, hash int, key K, value V) int {
+	if m.length >= m.growAt {
+		m.resize(len(m.buckets) * m.options().GrowthFactor)
+		i, dib, _ = m.find(hash, key)
 	}
+	m.insert(i, dib, entry[K, V]{makeHDIB(hash, dib), value, key})
+	m.length++
+	return i
+}
+
+// GetOrSet returns the value for a key. When the key does not exist, the
+// provided value is assigned and returned.
+// Returns true when the value was already assigned, or false when it was
+// assigned by this call.
+func (m *Map[K, V]) GetOrSet(key K, value V) (actual V, loaded bool) {
+	if len(m.buckets) == 0 {
+		m.init(0)
+	}
+	m.own()
+	hash := m.hash(key)
+	if m.old != nil {
+		m.settle(hash, key)
+	}
+	i, dib, found := m.find(hash, key)
+	if found {
+		return m.buckets[i].value, true
+	}
+	m.add(i, dib, hash, key, value)
+	return value, false
+}
+
+// Compute calls fn with the current value for a key, and assigns the value
+// that fn returns. The exists param is false when the key has no value, in
+// which case old is the zero value. When fn returns keep as false the key is
+// deleted, or not assigned.
+// Returns the new value, or false when the key has no value.
+// It's not safe to call Set or Delete from fn.
+func (m *Map[K, V]) Compute(key K,
+	fn func(old V, exists bool) (value V, keep bool),
+) (V, bool) {
+	if len(m.buckets) == 0 {
+		m.init(0)
+	}
+	return m.computeHashed(m.hash(key), key, fn)
+}
+
+// computeHashed is Compute for a key that has already been hashed.
+func (m *Map[K, V]) computeHashed(hash int, key K,
+	fn func(old V, exists bool) (value V, keep bool),
+) (V, bool) {
+	m.own()
+	if m.old != nil {
+		m.settle(hash, key)
+	}
+	i, dib, found := m.find(hash, key)
+	var old V
+	if found {
+		old = m.buckets[i].value
+	}
+	value, keep := fn(old, found)
+	switch {
+	case keep && found:
+		m.buckets[i].value = value
+	case keep:
+		m.add(i, dib, hash, key, value)
+	case found:
+		m.remove(i)
+	}
+	if !keep {
+		var zero V
+		return zero, false
+	}
+	return value, true
+}
+
+// Update calls fn with the current value for a key, or the zero value when
+// the key has no value, and assigns the value that fn returns.
+// Returns the new value.
+// It's not safe to call Set or Delete from fn.
+func (m *Map[K, V]) Update(key K, fn func(value V) V) V {
+	value, _ := m.Compute(key, func(old V, _ bool) (V, bool) {
+		return fn(old), true
+	})
+	return value
 }
 
 // Get returns a value for a key.
@@ -157,7 +620,14 @@ func (m *Map[K, V]) Get(key K) (value V, ok bool) {
 	if len(m.buckets) == 0 {
 		return value, false
 	}
-	hash := m.hash(key)
+	return m.getHashed(m.hash(key), key)
+}
+
+// getHashed is Get for a key that has already been hashed.
+func (m *Map[K, V]) getHashed(hash int, key K) (value V, ok bool) {
+	if m.old != nil {
+		return m.getResizing(hash, key)
+	}
 	i := hash & m.mask
 	for {
 		if m.buckets[i].dib() == 0 {
@@ -170,6 +640,319 @@ func (m *Map[K, V]) Get(key K) (value V, ok bool) {
 	}
 }
 
+// getResizing is Get while resizing incrementally.
+func (m *Map[K, V]) getResizing(hash int, key K) (value V, ok bool) {
+	m.own()
+	m.move(m.moveStep())
+	if i, _, found := m.find(hash, key); found {
+		return m.buckets[i].value, true
+	}
+	if m.old != nil {
+		if i := m.findOld(hash, key); i != -1 {
+			return m.old[i].value, true
+		}
+	}
+	return value, false
+}
+
+// GetPtr returns a pointer to the value for a key, allowing for the value
+// to be read or modified in place.
+// Returns nil when no value has been assign for key.
+// The pointer is only valid until the next Set, Delete, or Copy.
+func (m *Map[K, V]) GetPtr(key K) *V {
+	if len(m.buckets) == 0 {
+		return nil
+	}
+	m.own()
+	hash := m.hash(key)
+	if m.old != nil {
+		m.settle(hash, key)
+	}
+	i, _, found := m.find(hash, key)
+	if !found {
+		return nil
+	}
+	return &m.buckets[i].value
+}
+
+// SetPtr returns a pointer to the value for a key, allowing for the value
+// to be read or modified in place. When the key does not exist, it's
+// assigned the zero value first.
+// The pointer is only valid until the next Set, Delete, or Copy.
+func (m *Map[K, V]) SetPtr(key K) *V {
+	if len(m.buckets) == 0 {
+		m.init(0)
+	}
+	m.own()
+	hash := m.hash(key)
+	if m.old != nil {
+		m.settle(hash, key)
+	}
+	i, dib, found := m.find(hash, key)
+	if !found {
+		var value V
+		i = m.add(i, dib, hash, key, value)
+	}
+	return &m.buckets[i].value
+}
+
+// Entry is a handle to a single key in a Map. It allows for reading,
+// modifying, and deleting the value for the key without hashing or probing
+// the map more than once.
+// The entry is only valid until the next Set or Delete on the map.
+type Entry[K comparable, V any] struct {
+	m     *Map[K, V]
+	key   K
+	hash  int
+	i     int // position of the key, or -1 when unknown
+	dib   int
+	found bool
+}
+
+// Entry returns a handle for a key, which may or may not have a value.
+func (m *Map[K, V]) Entry(key K) *Entry[K, V] {
+	if len(m.buckets) == 0 {
+		m.init(0)
+	}
+	m.own()
+	e := &Entry[K, V]{m: m, key: key, hash: m.hash(key)}
+	if m.old != nil {
+		m.settle(e.hash, key)
+	}
+	e.i, e.dib, e.found = m.find(e.hash, key)
+	return e
+}
+
+// Key returns the key for the entry.
+func (e *Entry[K, V]) Key() K {
+	return e.key
+}
+
+// Exists returns true when the key has a value.
+func (e *Entry[K, V]) Exists() bool {
+	return e.found
+}
+
+// Value returns the value for the key.
+// Returns false when no value has been assign for key.
+func (e *Entry[K, V]) Value() (value V, ok bool) {
+	if !e.found {
+		return value, false
+	}
+	return e.m.buckets[e.i].value, true
+}
+
+// Ptr returns a pointer to the value for the key.
+// Returns nil when no value has been assign for key.
+func (e *Entry[K, V]) Ptr() *V {
+	if !e.found {
+		return nil
+	}
+	e.m.own()
+	return &e.m.buckets[e.i].value
+}
+
+// Set assigns a value to the key.
+// Returns the previous value, or false when no value was assigned.
+func (e *Entry[K, V]) Set(value V) (prev V, ok bool) {
+	e.m.own()
+	if e.found {
+		prev = e.m.buckets[e.i].value
+		e.m.buckets[e.i].value = value
+		return prev, true
+	}
+	if e.i == -1 {
+		e.i, e.dib, _ = e.m.find(e.hash, e.key)
+	}
+	e.i = e.m.add(e.i, e.dib, e.hash, e.key, value)
+	e.found = true
+	return prev, false
+}
+
+// Delete deletes the value for the key.
+// Returns the deleted value, or false when no value was assigned.
+func (e *Entry[K, V]) Delete() (prev V, deleted bool) {
+	if !e.found {
+		return prev, false
+	}
+	e.m.own()
+	prev = e.m.buckets[e.i].value
+	e.m.remove(e.i)
+	e.i = -1
+	e.found = false
+	return prev, true
+}
+
+const (
+	bulkSortMin  = 1 << 16 // fewer keys or buckets are not sorted
+	bulkSortBits = 12      // number of bucket regions is 1<<bulkSortBits
+)
+
+// hashedKey is a key that was hashed for a bulk operation.
+type hashedKey struct {
+	hash int
+	i    int // index of the key
+}
+
+// sortBulk finishes an incremental resize, and then hashes the keys and
+// orders them by their initial buckets, so that the buckets are accessed in
+// about memory order. Keys with the same initial bucket stay in their given
+// order.
+// Returns nil when there are too few keys or buckets for the order to
+// matter, and the keys are faster to use as given.
+func (m *Map[K, V]) sortBulk(keys []K) []hashedKey {
+	if m.old != nil {
+		m.own()
+		m.moveAll()
+	}
+	if len(keys) < bulkSortMin || len(m.buckets) < bulkSortMin {
+		return nil
+	}
+	// A counting sort on the high bits of the initial buckets, which splits
+	// the buckets into regions that are small enough to stay in cache. The
+	// keys are hashed into the second half, and sorted into the first.
+	shift := bits.Len(uint(m.mask)) - bulkSortBits
+	hkeys := make([]hashedKey, len(keys)*2)
+	hashed := hkeys[len(keys):]
+	var counts [1<<bulkSortBits + 1]int
+	for i := range keys {
+		hash := m.hash(keys[i])
+		hashed[i] = hashedKey{hash, i}
+		counts[(hash&m.mask)>>shift+1]++
+	}
+	for i := 1; i < len(counts); i++ {
+		counts[i] += counts[i-1]
+	}
+	for _, hk := range hashed {
+		r := (hk.hash & m.mask) >> shift
+		hkeys[counts[r]] = hk
+		counts[r]++
+	}
+	return hkeys[:len(keys):len(keys)]
+}
+
+// SetMany assigns values to keys, which is faster than calling Set for each
+// key when there are many keys. The map is grown once for all of the keys.
+// A key that is in keys more than once is assigned its last value.
+// Returns the number of keys that were added.
+// Panics when keys and values are not the same length.
+func (m *Map[K, V]) SetMany(keys []K, values []V) (added int) {
+	if len(keys) != len(values) {
+		panic("hashmap: SetMany keys and values have different lengths")
+	}
+	if len(keys) == 0 {
+		return 0
+	}
+	m.Reserve(len(keys))
+	m.own()
+	set := func(hash int, key K, value V) {
+		if _, ok := m.set(hash, key, value); !ok {
+			added++
+		}
+	}
+	if hkeys := m.sortBulk(keys); hkeys != nil {
+		for _, hk := range hkeys {
+			set(hk.hash, keys[hk.i], values[hk.i])
+		}
+	} else {
+		for i, key := range keys {
+			set(m.hash(key), key, values[i])
+		}
+	}
+	return added
+}
+
+// GetMany returns the values for keys. The found slice is true for each key
+// that has a value.
+func (m *Map[K, V]) GetMany(keys []K) (values []V, found []bool) {
+	values = make([]V, len(keys))
+	found = make([]bool, len(keys))
+	if m.length == 0 {
+		return values, found
+	}
+	if m.old != nil {
+		m.own()
+		m.moveAll()
+	}
+	// Lookups don't change the buckets, so they are as fast in the given
+	// order as when sorted.
+	for i, key := range keys {
+		values[i], found[i] = m.getHashed(m.hash(key), key)
+	}
+	return values, found
+}
+
+// DeleteMany deletes the values for keys, which is faster than calling
+// Delete for each key when there are many keys. The map is shrunk once for
+// all of the keys.
+// Returns the number of keys that were deleted.
+func (m *Map[K, V]) DeleteMany(keys []K) (deleted int) {
+	if m.length == 0 || len(keys) == 0 {
+		return 0
+	}
+	m.own()
+	del := func(hash int, key K) {
+		if i, _, ok := m.find(hash, key); ok {
+			m.eraseAt(i)
+			m.length--
+			deleted++
+		}
+	}
+	if hkeys := m.sortBulk(keys); hkeys != nil {
+		for _, hk := range hkeys {
+			del(hk.hash, keys[hk.i])
+		}
+	} else {
+		for _, key := range keys {
+			del(m.hash(key), key)
+		}
+	}
+	m.maybeShrink()
+	return deleted
+}
+
+// DeleteFunc deletes the values for which del returns true, in a single
+// pass over the map. The map is shrunk once after all of the values are
+// deleted. The del function must not change the map.
+// Returns the number of values that were deleted.
+func (m *Map[K, V]) DeleteFunc(del func(key K, value V) bool) (deleted int) {
+	if m.length == 0 {
+		return 0
+	}
+	m.own()
+	if m.old != nil {
+		m.moveAll()
+	}
+	// Start after an empty bucket. Erasing an entry shifts the following
+	// entries back, which never moves an entry past an empty bucket, so each
+	// entry is visited once.
+	start := 0
+	for m.buckets[start].dib() != 0 {
+		start++
+	}
+	for n := 1; n <= len(m.buckets); n++ {
+		i := (start + n) & m.mask
+		for m.buckets[i].dib() > 0 &&
+			del(m.buckets[i].key, m.buckets[i].value) {
+			// The next entry was shifted into this bucket.
+			m.eraseAt(i)
+			deleted++
+		}
+	}
+	m.length -= deleted
+	m.maybeShrink()
+	return deleted
+}
+
+// Retain deletes the values for which keep returns false. It's the same as
+// DeleteFunc, but with the result of the function inverted.
+// Returns the number of values that were deleted.
+func (m *Map[K, V]) Retain(keep func(key K, value V) bool) (deleted int) {
+	return m.DeleteFunc(func(key K, value V) bool {
+		return !keep(key, value)
+	})
+}
+
 // Len returns the number of values in map.
 func (m *Map[K, V]) Len() int {
 	return m.length
@@ -181,7 +964,15 @@ func (m *Map[K, V]) Delete(key K) (prev V, deleted bool) {
 	if len(m.buckets) == 0 {
 		return prev, false
 	}
-	hash := m.hash(key)
+	return m.deleteHashed(m.hash(key), key)
+}
+
+// deleteHashed is Delete for a key that has already been hashed.
+func (m *Map[K, V]) deleteHashed(hash int, key K) (prev V, deleted bool) {
+	m.own()
+	if m.old != nil {
+		m.settle(hash, key)
+	}
 	i := hash & m.mask
 	for {
 		if m.buckets[i].dib() == 0 {
@@ -196,42 +987,76 @@ func (m *Map[K, V]) Delete(key K) (prev V, deleted bool) {
 	}
 }
 
-func (m *Map[K, V]) remove(i int) {
-	m.buckets[i].setDIB(0)
+// erase removes the entry at position i by shifting the following entries
+// back, Robin Hood style.
+func erase[K any, V any](buckets []entry[K, V], mask int, i int) {
 	for {
 		pi := i
-		i = (i + 1) & m.mask
-		if m.buckets[i].dib() <= 1 {
-			m.buckets[pi] = entry[K, V]{}
-			break
+		i = (i + 1) & mask
+		if buckets[i].dib() <= 1 {
+			buckets[pi] = entry[K, V]{}
+			return
 		}
-		m.buckets[pi] = m.buckets[i]
-		m.buckets[pi].setDIB(m.buckets[pi].dib() - 1)
+		dib := dibAt(buckets, mask, i)
+		buckets[pi] = buckets[i]
+		buckets[pi].setDIB(dib - 1)
 	}
-	m.length--
+}
+
+// maybeShrink shrinks the map after deletes, when the load is at or below
+// the MinLoadFactor. The new size has room for one more entry, so that
+// adding a key right after does not grow the map again.
+func (m *Map[K, V]) maybeShrink() {
 	if len(m.buckets) > m.cap && m.length <= m.shrinkAt {
-		m.resize(m.length)
+		m.resize(m.sizeFor(m.length + 1))
 	}
 }
 
+func (m *Map[K, V]) remove(i int) {
+	m.eraseAt(i)
+	m.length--
+	m.maybeShrink()
+}
+
+// tables returns the bucket arrays. While resizing incrementally, the
+// entries that have not been moved yet are in the second array.
+func (m *Map[K, V]) tables() [2][]entry[K, V] {
+	return [2][]entry[K, V]{m.buckets, m.old}
+}
+
 // Scan iterates over all key/values.
 // It's not safe to call or Set or Delete while scanning.
 func (m *Map[K, V]) Scan(iter func(key K, value V) bool) {
-	for i := 0; i < len(m.buckets); i++ {
-		if m.buckets[i].dib() > 0 {
-			if !iter(m.buckets[i].key, m.buckets[i].value) {
-				return
+	for _, buckets := range m.tables() {
+		if !scanEntries(buckets, iter) {
+			return
+		}
+	}
+}
+
+// scanEntries calls iter for each entry in a bucket array.
+// Returns false when iter returns false.
+func scanEntries[K any, V any](buckets []entry[K, V],
+	iter func(key K, value V) bool,
+) bool {
+	for i := 0; i < len(buckets); i++ {
+		if buckets[i].dib() > 0 {
+			if !iter(buckets[i].key, buckets[i].value) {
+				return false
 			}
 		}
 	}
+	return true
 }
 
 // Keys returns all keys as a slice
 func (m *Map[K, V]) Keys() []K {
 	keys := make([]K, 0, m.length)
-	for i := 0; i < len(m.buckets); i++ {
-		if m.buckets[i].dib() > 0 {
-			keys = append(keys, m.buckets[i].key)
+	for _, buckets := range m.tables() {
+		for i := 0; i < len(buckets); i++ {
+			if buckets[i].dib() > 0 {
+				keys = append(keys, buckets[i].key)
+			}
 		}
 	}
 	return keys
@@ -240,31 +1065,237 @@ func (m *Map[K, V]) Keys() []K {
 // Values returns all values as a slice
 func (m *Map[K, V]) Values() []V {
 	values := make([]V, 0, m.length)
-	for i := 0; i < len(m.buckets); i++ {
-		if m.buckets[i].dib() > 0 {
-			values = append(values, m.buckets[i].value)
+	for _, buckets := range m.tables() {
+		for i := 0; i < len(buckets); i++ {
+			if buckets[i].dib() > 0 {
+				values = append(values, buckets[i].value)
+			}
 		}
 	}
 	return values
 }
 
-// Copy the hashmap.
+// All returns an iterator over all key/values.
+// It's not safe to call or Set or Delete while iterating.
+func (m *Map[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		m.Scan(yield)
+	}
+}
+
+// KeySeq returns an iterator over all keys.
+// It's not safe to call or Set or Delete while iterating.
+func (m *Map[K, V]) KeySeq() iter.Seq[K] {
+	return func(yield func(K) bool) {
+		for _, buckets := range m.tables() {
+			for i := 0; i < len(buckets); i++ {
+				if buckets[i].dib() > 0 {
+					if !yield(buckets[i].key) {
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
+// ValueSeq returns an iterator over all values.
+// It's not safe to call or Set or Delete while iterating.
+func (m *Map[K, V]) ValueSeq() iter.Seq[V] {
+	return func(yield func(V) bool) {
+		for _, buckets := range m.tables() {
+			for i := 0; i < len(buckets); i++ {
+				if buckets[i].dib() > 0 {
+					if !yield(buckets[i].value) {
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
+// Collect returns a new Map containing all key/values from seq.
+// When a key appears more than once, the last value wins.
+func Collect[K comparable, V any](seq iter.Seq2[K, V]) *Map[K, V] {
+	m := New[K, V](0)
+	for key, value := range seq {
+		m.Set(key, value)
+	}
+	return m
+}
+
+// Copy the hashmap. This is a copy-on-write operation and is very fast
+// because the bucket arrays are shared until either map is modified, at which
+// point the modified map clones them.
 func (m *Map[K, V]) Copy() *Map[K, V] {
 	m2 := new(Map[K, V])
 	*m2 = *m
-	m2.buckets = make([]entry[K, V], len(m.buckets))
-	copy(m2.buckets, m.buckets)
+	if m.refs != nil {
+		m.refs.Add(1)
+	}
 	return m2
 }
 
+// ScanCursor iterates over the map in batches, like the Redis SCAN command.
+// Start with a cursor of zero, and then call again with the returned next
+// cursor, until it's zero again. The count is the minimum number of
+// key/values to return in a batch, except for the last one. Default 10.
+//
+// The map can be modified between calls. Every key that is in the map for
+// the entire scan is returned at least once, even when the map is resized,
+// but a key may be returned more than once. Calling ScanCursor finishes an
+// incremental resize.
+func (m *Map[K, V]) ScanCursor(cursor uint64, count int,
+) (keys []K, values []V, next uint64) {
+	if m.length == 0 {
+		return nil, nil, 0
+	}
+	if m.old != nil {
+		m.own()
+		m.moveAll()
+	}
+	if count <= 0 {
+		count = 10
+	}
+	// The cursor is a home bucket with its bits reversed. Incrementing the
+	// reversed bits visits every bucket of a smaller or larger bucket array
+	// that has the same low bits as the buckets that were already visited.
+	mask := uint64(m.mask)
+	for {
+		keys, values = m.appendHome(keys, values, int(cursor&mask))
+		cursor |= ^mask
+		cursor = bits.Reverse64(bits.Reverse64(cursor) + 1)
+		if cursor == 0 || len(keys) >= count {
+			return keys, values, cursor
+		}
+	}
+}
+
+// appendHome appends the key/values with the home bucket b.
+func (m *Map[K, V]) appendHome(keys []K, values []V, b int) ([]K, []V) {
+	// Entries are ordered by their home buckets, so the entries for b
+	// follow b, after the entries for earlier home buckets.
+	for i, dib := b, 1; m.buckets[i].dib() > 0; i, dib = (i+1)&m.mask, dib+1 {
+		bdib := dibAt(m.buckets, m.mask, i)
+		if bdib < dib {
+			break
+		}
+		if bdib == dib {
+			keys = append(keys, m.buckets[i].key)
+			values = append(values, m.buckets[i].value)
+		}
+	}
+	return keys, values
+}
+
+// Random returns a random key/value. Every key/value is equally likely to be
+// returned, unlike GetPos. A nil rng uses the default source of the
+// math/rand package.
+// Returns false when the map is empty.
+func (m *Map[K, V]) Random(rng *rand.Rand) (key K, value V, ok bool) {
+	if m.length == 0 {
+		return key, value, false
+	}
+	e := m.slot(m.randomSlot(rng, nil))
+	return e.key, e.value, true
+}
+
+// SampleN returns up to n random key/values, without returning the same
+// key more than once. Every key/value is equally likely to be returned.
+// A nil rng uses the default source of the math/rand package.
+func (m *Map[K, V]) SampleN(rng *rand.Rand, n int) (keys []K, values []V) {
+	if n <= 0 || m.length == 0 {
+		return nil, nil
+	}
+	if n >= m.length {
+		return m.Keys(), m.Values()
+	}
+	keys = make([]K, 0, n)
+	values = make([]V, 0, n)
+	if n <= m.length/4 {
+		picked := make(map[int]bool, n)
+		for len(keys) < n {
+			i := m.randomSlot(rng, picked)
+			picked[i] = true
+			e := m.slot(i)
+			keys = append(keys, e.key)
+			values = append(values, e.value)
+		}
+		return keys, values
+	}
+	// Reservoir sampling
+	intn := randIntn(rng)
+	var seen int
+	for _, buckets := range m.tables() {
+		for i := range buckets {
+			if buckets[i].dib() == 0 {
+				continue
+			}
+			if seen < n {
+				keys = append(keys, buckets[i].key)
+				values = append(values, buckets[i].value)
+			} else if j := intn(seen + 1); j < n {
+				keys[j] = buckets[i].key
+				values[j] = buckets[i].value
+			}
+			seen++
+		}
+	}
+	return keys, values
+}
+
+func randIntn(rng *rand.Rand) func(n int) int {
+	if rng == nil {
+		return rand.Intn
+	}
+	return rng.Intn
+}
+
+// slot returns the entry at position i of the buckets, followed by the old
+// buckets while resizing incrementally.
+func (m *Map[K, V]) slot(i int) *entry[K, V] {
+	if i < len(m.buckets) {
+		return &m.buckets[i]
+	}
+	return &m.old[i-len(m.buckets)]
+}
+
+// randomSlot returns the position of a random entry that is not in picked.
+func (m *Map[K, V]) randomSlot(rng *rand.Rand, picked map[int]bool) int {
+	intn := randIntn(rng)
+	// Picking random buckets until one is used is uniform, but slow when
+	// most of the buckets are empty, in which case a random entry is found
+	// by counting the entries instead.
+	nslots := len(m.buckets) + len(m.old)
+	for tries := 0; tries < 64; tries++ {
+		i := intn(nslots)
+		if m.slot(i).dib() > 0 && !picked[i] {
+			return i
+		}
+	}
+	rank := intn(m.length - len(picked))
+	for i := 0; ; i++ {
+		if m.slot(i).dib() > 0 && !picked[i] {
+			if rank == 0 {
+				return i
+			}
+			rank--
+		}
+	}
+}
+
 // GetPos gets a single keys/value nearby a position.
 // The pos param can be any valid uint64. Useful for grabbing a random item
-// from the map.
+// from the map, but keys that follow empty buckets are more likely to be
+// returned. Use Random for a uniform distribution.
 func (m *Map[K, V]) GetPos(pos uint64) (key K, value V, ok bool) {
-	for i := 0; i < len(m.buckets); i++ {
-		index := (pos + uint64(i)) & uint64(m.mask)
-		if m.buckets[index].dib() > 0 {
-			return m.buckets[index].key, m.buckets[index].value, true
+	for _, buckets := range m.tables() {
+		for i := 0; i < len(buckets); i++ {
+			index := (pos + uint64(i)) & uint64(len(buckets)-1)
+			if buckets[index].dib() > 0 {
+				return buckets[index].key, buckets[index].value, true
+			}
 		}
 	}
 	// Empty map
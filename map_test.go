@@ -6,6 +6,7 @@ package hashmap
 
 import (
 	"fmt"
+	"math"
 	"math/rand"
 	"os"
 	"reflect"
@@ -604,3 +605,878 @@ func TestIssue3(t *testing.T) {
 		}
 	}
 }
+
+func TestHasher(t *testing.T) {
+	type idKey struct {
+		Kind byte
+		ID   int
+	}
+	// A hasher that produces lots of collisions
+	m := NewWithHasher[idKey, int](0, func(key idKey) uint64 {
+		return uint64(key.ID%10) << 32
+	})
+	for i := 0; i < 1000; i++ {
+		m.Set(idKey{'a', i}, i)
+		m.Set(idKey{'b', i}, -i)
+	}
+	if m.Len() != 2000 {
+		t.Fatalf("expected %v, got %v", 2000, m.Len())
+	}
+	for i := 0; i < 1000; i++ {
+		if v, ok := m.Get(idKey{'a', i}); !ok || v != i {
+			t.Fatalf("expected %v, got %v", i, v)
+		}
+		if v, ok := m.Get(idKey{'b', i}); !ok || v != -i {
+			t.Fatalf("expected %v, got %v", -i, v)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		if v, ok := m.Delete(idKey{'a', i}); !ok || v != i {
+			t.Fatalf("expected %v, got %v", i, v)
+		}
+	}
+	if m.Len() != 1000 {
+		t.Fatalf("expected %v, got %v", 1000, m.Len())
+	}
+	for i := 0; i < 1000; i++ {
+		if _, ok := m.Get(idKey{'a', i}); ok {
+			t.Fatal("expected false")
+		}
+		if v, ok := m.Get(idKey{'b', i}); !ok || v != -i {
+			t.Fatalf("expected %v, got %v", -i, v)
+		}
+	}
+	// the hasher must survive a copy
+	m2 := m.Copy()
+	for i := 0; i < 1000; i++ {
+		m2.Set(idKey{'c', i}, i)
+	}
+	if v, ok := m2.Get(idKey{'c', 999}); !ok || v != 999 {
+		t.Fatalf("expected %v, got %v", 999, v)
+	}
+}
+
+func TestSeed(t *testing.T) {
+	// Maps have their own random seeds
+	var m1, m2 Map[string, int]
+	m1.Set("hello", 1)
+	m2.Set("hello", 1)
+	if m1.seed == m2.seed || m1.hash("hello") == m2.hash("hello") {
+		t.Fatal("expected different seeds")
+	}
+	// Maps with a fixed seed have the same layout
+	m3 := NewWithSeed[string, int](0, 1234)
+	m4 := NewWithSeed[string, int](0, 1234)
+	for i := 0; i < 1000; i++ {
+		m3.Set(k(i), i)
+		m4.Set(k(i), i)
+	}
+	if !reflect.DeepEqual(m3.Keys(), m4.Keys()) {
+		t.Fatal("expected equal keys")
+	}
+	// The seed must survive a resize
+	for i := 0; i < 1000; i++ {
+		if v, ok := m3.Get(k(i)); !ok || v != i {
+			t.Fatalf("expected %v, got %v", i, v)
+		}
+	}
+	if m3.seed != 1234 {
+		t.Fatalf("expected %v, got %v", 1234, m3.seed)
+	}
+}
+
+// checkDIBs checks that each entry is stored with the correct DIB.
+func checkDIBs[K comparable, V any](t *testing.T, m *Map[K, V]) {
+	t.Helper()
+	var n int
+	for i := range m.buckets {
+		if m.buckets[i].dib() == 0 {
+			continue
+		}
+		n++
+		dib := (i-m.buckets[i].hash())&m.mask + 1
+		if dibAt(m.buckets, m.mask, i) != dib {
+			t.Fatalf("expected %v, got %v", dib, dibAt(m.buckets, m.mask, i))
+		}
+		if uint64(m.buckets[i].dib()) != clampDIB(dib) {
+			t.Fatalf("expected %v, got %v", clampDIB(dib), m.buckets[i].dib())
+		}
+	}
+	if n != m.Len() {
+		t.Fatalf("expected %v, got %v", m.Len(), n)
+	}
+}
+
+func TestDIBOverflow(t *testing.T) {
+	// A hasher that puts every even key into the same bucket.
+	const home = 7
+	hasher := func(key int) uint64 {
+		if key%2 == 0 {
+			return home << dibBitSize
+		}
+		return uint64(key) << dibBitSize
+	}
+	m := NewWithHasher[int, int](1<<17, hasher)
+	// Constructing a cluster that is longer than maxDIB using Set would take
+	// a very long time, so the buckets are filled in by hand.
+	N := int(maxDIB) + 1000
+	for i := 0; i < N; i++ {
+		m.buckets[(home+i)&m.mask] = entry[int, int]{
+			makeHDIB(home, i+1), i * 10, i * 2,
+		}
+	}
+	m.length = N
+	checkDIBs(t, m)
+
+	// Add keys to the end of the cluster
+	for i := N; i < N+10; i++ {
+		if _, ok := m.Set(i*2, i*10); ok {
+			t.Fatal("expected false")
+		}
+	}
+	// Add keys that have an initial bucket in the middle of the cluster
+	for i := 0; i < 10; i++ {
+		if _, ok := m.Set(1001+i*2, i); ok {
+			t.Fatal("expected false")
+		}
+	}
+	checkDIBs(t, m)
+	for _, i := range []int{0, 1, N / 2, N - 1, N, N + 9} {
+		if v, ok := m.Get(i * 2); !ok || v != i*10 {
+			t.Fatalf("expected %v, got %v", i*10, v)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		if v, ok := m.Get(1001 + i*2); !ok || v != i {
+			t.Fatalf("expected %v, got %v", i, v)
+		}
+	}
+	if _, ok := m.Get((N + 10) * 2); ok {
+		t.Fatal("expected false")
+	}
+
+	// Delete from the front, middle, and end of the cluster
+	for _, i := range []int{0, N / 2, N + 9, 1} {
+		if v, ok := m.Delete(i * 2); !ok || v != i*10 {
+			t.Fatalf("expected %v, got %v", i*10, v)
+		}
+		if _, ok := m.Get(i * 2); ok {
+			t.Fatal("expected false")
+		}
+	}
+	for i := 0; i < 10; i++ {
+		if v, ok := m.Delete(1001 + i*2); !ok || v != i {
+			t.Fatalf("expected %v, got %v", i, v)
+		}
+	}
+	checkDIBs(t, m)
+	if m.Len() != N+6 {
+		t.Fatalf("expected %v, got %v", N+6, m.Len())
+	}
+	for _, i := range []int{2, N / 3, N - 1, N, N + 8} {
+		if v, ok := m.Get(i * 2); !ok || v != i*10 {
+			t.Fatalf("expected %v, got %v", i*10, v)
+		}
+	}
+}
+
+func TestIterators(t *testing.T) {
+	var m Map[int, int]
+	for range m.All() {
+		t.Fatal("expected empty")
+	}
+	for i := 0; i < 1000; i++ {
+		m.Set(i, i*10)
+	}
+	m2 := make(map[int]int)
+	for key, value := range m.All() {
+		m2[key] = value
+	}
+	if len(m2) != m.Len() {
+		t.Fatalf("expected %v, got %v", m.Len(), len(m2))
+	}
+	for key, value := range m2 {
+		if value != key*10 {
+			t.Fatalf("expected %v, got %v", key*10, value)
+		}
+	}
+	var keys, values []int
+	for key := range m.KeySeq() {
+		keys = append(keys, key)
+	}
+	for value := range m.ValueSeq() {
+		values = append(values, value)
+	}
+	if !reflect.DeepEqual(keys, m.Keys()) {
+		t.Fatal("expected Keys equal")
+	}
+	if !reflect.DeepEqual(values, m.Values()) {
+		t.Fatal("expected Values equal")
+	}
+	var n int
+	for range m.All() {
+		n++
+		if n == 10 {
+			break
+		}
+	}
+	if n != 10 {
+		t.Fatalf("expected %v, got %v", 10, n)
+	}
+	m3 := Collect(m.All())
+	if m3.Len() != m.Len() {
+		t.Fatalf("expected %v, got %v", m.Len(), m3.Len())
+	}
+	for key, value := range m.All() {
+		if v, ok := m3.Get(key); !ok || v != value {
+			t.Fatalf("expected %v, got %v", value, v)
+		}
+	}
+}
+
+func TestGetOrSet(t *testing.T) {
+	var m Map[string, int]
+	for i := 0; i < 1000; i++ {
+		v, loaded := m.GetOrSet(k(i), i)
+		if loaded || v != i {
+			t.Fatalf("expected %v, got %v", i, v)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		v, loaded := m.GetOrSet(k(i), -1)
+		if !loaded || v != i {
+			t.Fatalf("expected %v, got %v", i, v)
+		}
+	}
+	if m.Len() != 1000 {
+		t.Fatalf("expected %v, got %v", 1000, m.Len())
+	}
+	checkDIBs(t, &m)
+}
+
+func TestCompute(t *testing.T) {
+	var m Map[int, int]
+	keys := rand.Perm(10000)
+	// insert
+	for _, key := range keys {
+		v, ok := m.Compute(key, func(old int, exists bool) (int, bool) {
+			if exists || old != 0 {
+				t.Fatal("expected not exists")
+			}
+			return key * 10, true
+		})
+		if !ok || v != key*10 {
+			t.Fatalf("expected %v, got %v", key*10, v)
+		}
+	}
+	// modify
+	shuffle(keys)
+	for _, key := range keys {
+		v, ok := m.Compute(key, func(old int, exists bool) (int, bool) {
+			if !exists || old != key*10 {
+				t.Fatalf("expected %v, got %v", key*10, old)
+			}
+			return old + 1, true
+		})
+		if !ok || v != key*10+1 {
+			t.Fatalf("expected %v, got %v", key*10+1, v)
+		}
+	}
+	// delete half, and don't insert missing keys
+	shuffle(keys)
+	for i, key := range keys {
+		_, ok := m.Compute(key, func(old int, exists bool) (int, bool) {
+			return old, i%2 == 0
+		})
+		if ok != (i%2 == 0) {
+			t.Fatalf("expected %v, got %v", i%2 == 0, ok)
+		}
+		_, ok = m.Compute(-key-1, func(old int, exists bool) (int, bool) {
+			return 0, false
+		})
+		if ok {
+			t.Fatal("expected false")
+		}
+	}
+	if m.Len() != len(keys)/2 {
+		t.Fatalf("expected %v, got %v", len(keys)/2, m.Len())
+	}
+	checkDIBs(t, &m)
+	for i, key := range keys {
+		v, ok := m.Get(key)
+		if ok != (i%2 == 0) || (ok && v != key*10+1) {
+			t.Fatalf("expected %v, got %v", key*10+1, v)
+		}
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	var m Map[string, int]
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 1000; j++ {
+			v := m.Update(k(j), func(v int) int { return v + 1 })
+			if v != i+1 {
+				t.Fatalf("expected %v, got %v", i+1, v)
+			}
+		}
+	}
+	if m.Len() != 1000 {
+		t.Fatalf("expected %v, got %v", 1000, m.Len())
+	}
+	for j := 0; j < 1000; j++ {
+		if v, _ := m.Get(k(j)); v != 10 {
+			t.Fatalf("expected %v, got %v", 10, v)
+		}
+	}
+}
+
+type bigValue struct {
+	count int
+	data  [64]int
+}
+
+func TestGetPtr(t *testing.T) {
+	var m Map[int, bigValue]
+	if m.GetPtr(1) != nil {
+		t.Fatal("expected nil")
+	}
+	for i := 0; i < 1000; i++ {
+		p := m.SetPtr(i)
+		if p.count != 0 {
+			t.Fatalf("expected %v, got %v", 0, p.count)
+		}
+		p.count = i
+		p.data[63] = i
+	}
+	for i := 0; i < 1000; i++ {
+		m.SetPtr(i).count++
+		m.GetPtr(i).data[0] = i
+	}
+	if m.Len() != 1000 {
+		t.Fatalf("expected %v, got %v", 1000, m.Len())
+	}
+	for i := 0; i < 1000; i++ {
+		v, _ := m.Get(i)
+		if v.count != i+1 || v.data[0] != i || v.data[63] != i {
+			t.Fatalf("expected %v, got %v", i+1, v.count)
+		}
+	}
+	if m.GetPtr(1000) != nil {
+		t.Fatal("expected nil")
+	}
+}
+
+func TestEntry(t *testing.T) {
+	var m Map[string, int]
+	for i := 0; i < 1000; i++ {
+		e := m.Entry(k(i))
+		if e.Exists() || e.Ptr() != nil || e.Key() != k(i) {
+			t.Fatal("expected not exists")
+		}
+		if _, ok := e.Value(); ok {
+			t.Fatal("expected false")
+		}
+		if _, ok := e.Set(i); ok {
+			t.Fatal("expected false")
+		}
+		if v, ok := e.Value(); !ok || v != i {
+			t.Fatalf("expected %v, got %v", i, v)
+		}
+		*e.Ptr() += 1
+	}
+	checkDIBs(t, &m)
+	for i := 0; i < 1000; i++ {
+		e := m.Entry(k(i))
+		if v, ok := e.Value(); !ok || v != i+1 {
+			t.Fatalf("expected %v, got %v", i+1, v)
+		}
+		if i%2 == 0 {
+			if v, ok := e.Delete(); !ok || v != i+1 {
+				t.Fatalf("expected %v, got %v", i+1, v)
+			}
+			if _, ok := e.Delete(); ok {
+				t.Fatal("expected false")
+			}
+			if e.Exists() {
+				t.Fatal("expected not exists")
+			}
+			if i%4 == 0 {
+				// add it back
+				e.Set(-i)
+			}
+		} else {
+			if prev, ok := e.Set(i * 10); !ok || prev != i+1 {
+				t.Fatalf("expected %v, got %v", i+1, prev)
+			}
+		}
+	}
+	checkDIBs(t, &m)
+	if m.Len() != 750 {
+		t.Fatalf("expected %v, got %v", 750, m.Len())
+	}
+	for i := 0; i < 1000; i++ {
+		v, ok := m.Get(k(i))
+		switch {
+		case i%4 == 0:
+			if !ok || v != -i {
+				t.Fatalf("expected %v, got %v", -i, v)
+			}
+		case i%2 == 0:
+			if ok {
+				t.Fatal("expected false")
+			}
+		default:
+			if !ok || v != i*10 {
+				t.Fatalf("expected %v, got %v", i*10, v)
+			}
+		}
+	}
+}
+
+func TestCapacity(t *testing.T) {
+	var m Map[int, int]
+	m.ShrinkToFit()
+	m.Clear()
+	m.Reserve(1000)
+	nbuckets := len(m.buckets)
+	if m.growAt < 1000 {
+		t.Fatalf("expected >= %v, got %v", 1000, m.growAt)
+	}
+	for i := 0; i < 1000; i++ {
+		m.Set(i, i)
+	}
+	if len(m.buckets) != nbuckets {
+		t.Fatalf("expected %v, got %v", nbuckets, len(m.buckets))
+	}
+	m.Reserve(10)
+	m.Reserve(-10)
+	for i := 0; i < 1000; i++ {
+		if v, ok := m.Get(i); !ok || v != i {
+			t.Fatalf("expected %v, got %v", i, v)
+		}
+	}
+	m.Clear()
+	if m.Len() != 0 || len(m.buckets) != nbuckets {
+		t.Fatal("expected empty map with the same buckets")
+	}
+	if _, ok := m.Get(1); ok {
+		t.Fatal("expected false")
+	}
+	for i := 0; i < 100; i++ {
+		m.Set(i, i)
+	}
+	m.ShrinkToFit()
+	if len(m.buckets) != 128 {
+		t.Fatalf("expected %v, got %v", 128, len(m.buckets))
+	}
+	for i := 0; i < 100; i++ {
+		if v, ok := m.Get(i); !ok || v != i {
+			t.Fatalf("expected %v, got %v", i, v)
+		}
+	}
+	checkDIBs(t, &m)
+	m.Clear()
+	m.ShrinkToFit()
+	if len(m.buckets) != 8 {
+		t.Fatalf("expected %v, got %v", 8, len(m.buckets))
+	}
+	// never shrink below the initial capacity
+	m2 := New[int, int](1000)
+	m2.Set(1, 1)
+	m2.ShrinkToFit()
+	if len(m2.buckets) != 1024 {
+		t.Fatalf("expected %v, got %v", 1024, len(m2.buckets))
+	}
+}
+
+func TestCopyOnWrite(t *testing.T) {
+	for _, opts := range []*Options{nil, {Incremental: true}} {
+		m1 := NewWithOptions[int, int](0, opts)
+		for i := 0; i < 1000; i++ {
+			m1.Set(i, i)
+		}
+		m2 := m1.Copy()
+		m3 := m2.Copy()
+		if allocs := testing.AllocsPerRun(100, func() { m1.Copy() }); allocs > 1 {
+			t.Fatalf("expected %v, got %v", 1, allocs)
+		}
+		// mutate each copy differently
+		for i := 0; i < 1000; i += 2 {
+			m1.Delete(i)
+		}
+		for i := 1000; i < 2000; i++ {
+			m2.Set(i, i)
+		}
+		*m3.GetPtr(1) = -1
+		m3.Entry(2).Set(-2)
+		m4 := m3.Copy()
+		m4.Clear()
+		for i := 0; i < 2000; i++ {
+			v1, ok1 := m1.Get(i)
+			if ok1 != (i < 1000 && i%2 == 1) || (ok1 && v1 != i) {
+				t.Fatalf("key %v: got %v %v", i, v1, ok1)
+			}
+			v2, ok2 := m2.Get(i)
+			if !ok2 || v2 != i {
+				t.Fatalf("key %v: got %v %v", i, v2, ok2)
+			}
+			v3, ok3 := m3.Get(i)
+			want := i
+			if i == 1 || i == 2 {
+				want = -i
+			}
+			if ok3 != (i < 1000) || (ok3 && v3 != want) {
+				t.Fatalf("key %v: got %v %v", i, v3, ok3)
+			}
+		}
+		if m1.Len() != 500 || m2.Len() != 2000 || m3.Len() != 1000 ||
+			m4.Len() != 0 {
+			t.Fatalf("got %v %v %v %v", m1.Len(), m2.Len(), m3.Len(),
+				m4.Len())
+		}
+		if len(m4.Keys()) != 0 {
+			t.Fatal("expected empty")
+		}
+	}
+}
+
+func TestScanCursor(t *testing.T) {
+	for _, opts := range []*Options{nil, {Incremental: true}} {
+		m := NewWithOptions[int, int](0, opts)
+		if keys, _, next := m.ScanCursor(0, 10); len(keys) != 0 || next != 0 {
+			t.Fatal("expected empty")
+		}
+		// Keys below 1000 stay for the entire scan, and others come and go,
+		// growing and shrinking the map.
+		for i := 0; i < 1000; i++ {
+			m.Set(i, i)
+		}
+		seen := make(map[int]bool)
+		var cursor uint64
+		var calls int
+		for {
+			keys, values, next := m.ScanCursor(cursor, 50)
+			for i, key := range keys {
+				if values[i] != key {
+					t.Fatalf("expected %v, got %v", key, values[i])
+				}
+				seen[key] = true
+			}
+			calls++
+			switch {
+			case calls < 10:
+				for i := 0; i < 2000; i++ {
+					m.Set(1000+calls*2000+i, 1000+calls*2000+i)
+				}
+			case calls < 20:
+				for i := 0; i < 2000; i++ {
+					m.Delete(1000 + (calls-10)*2000 + i)
+				}
+			}
+			cursor = next
+			if cursor == 0 {
+				break
+			}
+		}
+		for i := 0; i < 1000; i++ {
+			if !seen[i] {
+				t.Fatalf("key %v was not seen", i)
+			}
+		}
+	}
+}
+
+func TestRandom(t *testing.T) {
+	var empty Map[int, int]
+	if _, _, ok := empty.Random(nil); ok {
+		t.Fatal("expected false")
+	}
+	// Leave long runs of empty buckets, which GetPos is biased by.
+	m := NewWithOptions[int, int](0, &Options{NoShrink: true})
+	for i := 0; i < 5000; i++ {
+		m.Set(i, i)
+	}
+	for i := 0; i < 5000; i++ {
+		if i%50 != 0 && i%7 != 0 {
+			m.Delete(i)
+		}
+	}
+	n := m.Len()
+	rng := rand.New(rand.NewSource(1))
+	const draws = 200000
+	counts := make(map[int]int)
+	for i := 0; i < draws; i++ {
+		key, value, ok := m.Random(rng)
+		if !ok || key != value {
+			t.Fatalf("expected %v, got %v", key, value)
+		}
+		counts[key]++
+	}
+	// The 0.1% critical value of the chi-squared distribution for n-1
+	// degrees of freedom is about n+3.1*sqrt(2n).
+	limit := float64(n) + 3.1*math.Sqrt(2*float64(n))
+	if chi := chiSquared(counts, n, draws/float64(n)); chi > limit {
+		t.Fatalf("chi-squared %.1f is above %.1f", chi, limit)
+	}
+
+	// Each key is in a sample of 10 with the same probability.
+	counts = make(map[int]int)
+	for i := 0; i < draws/10; i++ {
+		keys, _ := m.SampleN(rng, 10)
+		seen := make(map[int]bool)
+		for _, key := range keys {
+			if seen[key] {
+				t.Fatalf("key %v was sampled twice", key)
+			}
+			seen[key] = true
+			counts[key]++
+		}
+	}
+	if chi := chiSquared(counts, n, draws/float64(n)); chi > limit {
+		t.Fatalf("chi-squared %.1f is above %.1f", chi, limit)
+	}
+	// reservoir sampling
+	for _, k := range []int{n / 2, n, n + 1} {
+		keys, values := m.SampleN(nil, k)
+		if len(keys) != min(k, n) || len(values) != len(keys) {
+			t.Fatalf("expected %v, got %v", min(k, n), len(keys))
+		}
+	}
+}
+
+func chiSquared(counts map[int]int, n int, expected float64) float64 {
+	if len(counts) != n {
+		return math.Inf(1)
+	}
+	var chi float64
+	for _, count := range counts {
+		d := float64(count) - expected
+		chi += d * d / expected
+	}
+	return chi
+}
+
+func TestBulk(t *testing.T) {
+	// Enough keys to be sorted by their buckets.
+	for _, n := range []int{10000, bulkSortMin * 2} {
+		for _, opts := range []*Options{nil, {Incremental: true}} {
+			testBulk(t, n, opts)
+		}
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expected panic")
+			}
+		}()
+		new(Map[int, int]).SetMany([]int{1}, nil)
+	}()
+}
+
+func testBulk(t *testing.T, n int, opts *Options) {
+	m := NewWithOptions[string, int](0, opts)
+	keys := random(n, true)
+	values := make([]int, len(keys))
+	for i := range keys {
+		values[i] = add(keys[i], 0)
+	}
+	// some keys already exist, and one is given twice
+	for i := 0; i < 100; i++ {
+		m.Set(keys[i], -1)
+	}
+	keys = append(keys, keys[n/2])
+	values = append(values, -2)
+	if added := m.SetMany(keys, values); added != n-100 {
+		t.Fatalf("expected %v, got %v", n-100, added)
+	}
+	want := values[n/2]
+	values[n/2] = -2
+	got, found := m.GetMany(append(keys, "missing"))
+	for i := range keys {
+		if !found[i] || got[i] != values[i] {
+			t.Fatalf("key %v: expected %v, got %v", keys[i], values[i],
+				got[i])
+		}
+	}
+	if found[len(keys)] {
+		t.Fatal("expected false")
+	}
+	values[n/2] = want
+	m.Set("other", 1)
+	if deleted := m.DeleteMany(keys); deleted != n {
+		t.Fatalf("expected %v, got %v", n, deleted)
+	}
+	if m.Len() != 1 || len(m.buckets) > 8 {
+		t.Fatalf("expected %v, got %v", 1, m.Len())
+	}
+	if v, _ := m.Get("other"); v != 1 {
+		t.Fatalf("expected %v, got %v", 1, v)
+	}
+}
+
+const benchBulkN = 1 << 20
+
+func benchBulkKeys() ([]int, []int) {
+	rng := rand.New(rand.NewSource(1))
+	keys := make([]int, benchBulkN)
+	for i := range keys {
+		keys[i] = rng.Int()
+	}
+	return keys, keys
+}
+
+func BenchmarkSetLoop(b *testing.B) {
+	keys, values := benchBulkKeys()
+	b.ResetTimer()
+	for j := 0; j < b.N; j++ {
+		var m Map[int, int]
+		for i := range keys {
+			m.Set(keys[i], values[i])
+		}
+	}
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*benchBulkN),
+		"ns/key")
+}
+
+// BenchmarkSetReserveLoop is the baseline for SetMany, which also grows the
+// map once for all of the keys.
+func BenchmarkSetReserveLoop(b *testing.B) {
+	keys, values := benchBulkKeys()
+	b.ResetTimer()
+	for j := 0; j < b.N; j++ {
+		var m Map[int, int]
+		m.Reserve(len(keys))
+		for i := range keys {
+			m.Set(keys[i], values[i])
+		}
+	}
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*benchBulkN),
+		"ns/key")
+}
+
+func BenchmarkSetMany(b *testing.B) {
+	keys, values := benchBulkKeys()
+	b.ResetTimer()
+	for j := 0; j < b.N; j++ {
+		var m Map[int, int]
+		m.SetMany(keys, values)
+	}
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*benchBulkN),
+		"ns/key")
+}
+
+func BenchmarkGetLoop(b *testing.B) {
+	keys, values := benchBulkKeys()
+	var m Map[int, int]
+	m.SetMany(keys, values)
+	b.ResetTimer()
+	for j := 0; j < b.N; j++ {
+		for i := range keys {
+			m.Get(keys[i])
+		}
+	}
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*benchBulkN),
+		"ns/key")
+}
+
+func BenchmarkGetMany(b *testing.B) {
+	keys, values := benchBulkKeys()
+	var m Map[int, int]
+	m.SetMany(keys, values)
+	b.ResetTimer()
+	for j := 0; j < b.N; j++ {
+		m.GetMany(keys)
+	}
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*benchBulkN),
+		"ns/key")
+}
+
+func TestDeleteFunc(t *testing.T) {
+	for _, opts := range []*Options{nil, {Incremental: true}} {
+		m := NewWithOptions[int, int](0, opts)
+		for i := 0; i < 10000; i++ {
+			m.Set(i, i*2)
+		}
+		m2 := m.Copy()
+		deleted := m.DeleteFunc(func(key, value int) bool {
+			if value != key*2 {
+				t.Fatalf("expected %v, got %v", key*2, value)
+			}
+			return key%10 != 0
+		})
+		if deleted != 9000 || m.Len() != 1000 {
+			t.Fatalf("expected %v, got %v", 9000, deleted)
+		}
+		if len(m.buckets) > 2048 {
+			t.Fatalf("expected shrink, got %v buckets", len(m.buckets))
+		}
+		for i := 0; i < 10000; i++ {
+			_, ok := m.Get(i)
+			if ok != (i%10 == 0) {
+				t.Fatalf("key %v: expected %v, got %v", i, i%10 == 0, ok)
+			}
+		}
+		if m.old != nil {
+			m.moveAll()
+		}
+		checkDIBs(t, m)
+		if m2.Len() != 10000 {
+			t.Fatalf("expected %v, got %v", 10000, m2.Len())
+		}
+		if n := m.DeleteFunc(func(int, int) bool { return true }); n != 1000 {
+			t.Fatalf("expected %v, got %v", 1000, n)
+		}
+		if m.Len() != 0 {
+			t.Fatalf("expected %v, got %v", 0, m.Len())
+		}
+	}
+	// Entries that wrap around the end of the buckets.
+	m := NewWithHasher[int, int](0, func(key int) uint64 {
+		return uint64(14-key%4) << dibBitSize
+	})
+	for i := 0; i < 12; i++ {
+		m.Set(i, i)
+	}
+	if len(m.buckets) != 16 {
+		t.Fatalf("expected %v, got %v", 16, len(m.buckets))
+	}
+	visited := make(map[int]int)
+	n := m.DeleteFunc(func(key, _ int) bool {
+		visited[key]++
+		return key%2 == 1
+	})
+	if n != 6 {
+		t.Fatalf("expected %v, got %v", 6, n)
+	}
+	for i := 0; i < 12; i++ {
+		if visited[i] != 1 {
+			t.Fatalf("key %v: expected %v, got %v", i, 1, visited[i])
+		}
+	}
+	checkDIBs(t, m)
+	for i := 0; i < 12; i++ {
+		if _, ok := m.Get(i); ok != (i%2 == 0) {
+			t.Fatalf("key %v: expected %v, got %v", i, i%2 == 0, ok)
+		}
+	}
+	var s Set[int]
+	for i := 0; i < 100; i++ {
+		s.Insert(i)
+	}
+	if n := s.DeleteFunc(func(key int) bool { return key >= 10 }); n != 90 {
+		t.Fatalf("expected %v, got %v", 90, n)
+	}
+	if s.Len() != 10 || !s.Contains(9) || s.Contains(10) {
+		t.Fatalf("expected %v, got %v", 10, s.Len())
+	}
+	if n := s.Retain(func(key int) bool { return key < 5 }); n != 5 {
+		t.Fatalf("expected %v, got %v", 5, n)
+	}
+	if s.Len() != 5 || !s.Contains(4) || s.Contains(5) {
+		t.Fatalf("expected %v, got %v", 5, s.Len())
+	}
+	m.Retain(func(key, value int) bool { return key == 2 })
+	if _, ok := m.Get(2); !ok || m.Len() != 1 {
+		t.Fatalf("expected %v, got %v", 1, m.Len())
+	}
+}
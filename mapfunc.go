@@ -0,0 +1,186 @@
+// Copyright 2019 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an ISC-style
+// license that can be found in the LICENSE file.
+
+package hashmap
+
+import (
+	"slices"
+	"sync/atomic"
+)
+
+// MapFunc is a hashmap that uses user provided hash and equality functions
+// for keys. This allows for keys that are not comparable, such as []byte, or
+// for keys with custom equality, such as case-insensitive strings.
+//
+// For example, a map with []byte keys:
+//
+//	m := hashmap.NewFunc[[]byte, int](0, xxh3.Hash, bytes.Equal)
+type MapFunc[K any, V any] struct {
+	cap      int
+	length   int
+	mask     int
+	growAt   int
+	shrinkAt int
+	buckets  []entry[K, V]
+	hasher   func(key K) uint64
+	equal    func(a, b K) bool
+
+	// The bucket array may be shared with copies of the map, like Map.
+	refs *atomic.Int32
+}
+
+// NewFunc returns a new MapFunc using the provided hash and equal functions.
+// Keys that are equal must always produce the same hash.
+func NewFunc[K any, V any](cap int, hash func(key K) uint64,
+	equal func(a, b K) bool,
+) *MapFunc[K, V] {
+	m := new(MapFunc[K, V])
+	m.hasher = hash
+	m.equal = equal
+	m.cap = cap
+	sz := 8
+	for sz < m.cap {
+		sz *= 2
+	}
+	if m.cap > 0 {
+		m.cap = sz
+	}
+	m.alloc(sz)
+	return m
+}
+
+func (m *MapFunc[K, V]) hash(key K) int {
+	return int(m.hasher(key) >> dibBitSize)
+}
+
+// alloc allocates an empty bucket array. The size must be a power of two.
+func (m *MapFunc[K, V]) alloc(sz int) {
+	m.buckets = make([]entry[K, V], sz)
+	m.refs = new(atomic.Int32)
+	m.refs.Store(1)
+	m.mask = len(m.buckets) - 1
+	m.growAt = int(float64(len(m.buckets)) * defaultOptions.MaxLoadFactor)
+	m.shrinkAt = int(float64(len(m.buckets)) * defaultOptions.MinLoadFactor)
+	m.length = 0
+}
+
+// own is called before modifying the map. The bucket array is cloned when
+// it's shared with a copy of the map.
+func (m *MapFunc[K, V]) own() {
+	if m.refs.Load() == 1 {
+		return
+	}
+	m.buckets = slices.Clone(m.buckets)
+	m.refs.Add(-1)
+	m.refs = new(atomic.Int32)
+	m.refs.Store(1)
+}
+
+func (m *MapFunc[K, V]) resize(newCap int) {
+	buckets := m.buckets
+	refs := m.refs
+	length := m.length
+	m.alloc(bucketsFor(newCap, m.cap, defaultOptions.MaxLoadFactor))
+	m.length = length
+	for i := 0; i < len(buckets); i++ {
+		if buckets[i].dib() > 0 {
+			insertEntry(m.buckets, m.mask, buckets[i].hash()&m.mask, 1,
+				buckets[i])
+		}
+	}
+	refs.Add(-1)
+}
+
+func (m *MapFunc[K, V]) find(hash int, key K) (i, dib int, found bool) {
+	return findEntry(m.buckets, m.mask, hash, key, m.equal)
+}
+
+// Set assigns a value to a key.
+// Returns the previous value, or false when no value was assigned.
+func (m *MapFunc[K, V]) Set(key K, value V) (prev V, replaced bool) {
+	m.own()
+	hash := m.hash(key)
+	i, dib, found := m.find(hash, key)
+	if found {
+		prev = m.buckets[i].value
+		m.buckets[i].value = value
+		return prev, true
+	}
+	if m.length >= m.growAt {
+		m.resize(len(m.buckets) * defaultOptions.GrowthFactor)
+		i, dib, _ = m.find(hash, key)
+	}
+	insertEntry(m.buckets, m.mask, i, dib,
+		entry[K, V]{makeHDIB(hash, dib), value, key})
+	m.length++
+	return prev, false
+}
+
+// Get returns a value for a key.
+// Returns false when no value has been assign for key.
+func (m *MapFunc[K, V]) Get(key K) (value V, ok bool) {
+	i, _, found := m.find(m.hash(key), key)
+	if !found {
+		return value, false
+	}
+	return m.buckets[i].value, true
+}
+
+// Len returns the number of values in map.
+func (m *MapFunc[K, V]) Len() int {
+	return m.length
+}
+
+// Delete deletes a value for a key.
+// Returns the deleted value, or false when no value was assigned.
+func (m *MapFunc[K, V]) Delete(key K) (prev V, deleted bool) {
+	i, _, found := m.find(m.hash(key), key)
+	if !found {
+		return prev, false
+	}
+	m.own()
+	prev = m.buckets[i].value
+	erase(m.buckets, m.mask, i)
+	m.length--
+	if len(m.buckets) > m.cap && m.length <= m.shrinkAt {
+		m.resize(m.length + 1)
+	}
+	return prev, true
+}
+
+// Scan iterates over all key/values.
+// It's not safe to call or Set or Delete while scanning.
+func (m *MapFunc[K, V]) Scan(iter func(key K, value V) bool) {
+	scanEntries(m.buckets, iter)
+}
+
+// Keys returns all keys as a slice
+func (m *MapFunc[K, V]) Keys() []K {
+	keys := make([]K, 0, m.length)
+	m.Scan(func(key K, _ V) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+// Values returns all values as a slice
+func (m *MapFunc[K, V]) Values() []V {
+	values := make([]V, 0, m.length)
+	m.Scan(func(_ K, value V) bool {
+		values = append(values, value)
+		return true
+	})
+	return values
+}
+
+// Copy the hashmap. This is a copy-on-write operation and is very fast
+// because the bucket array is shared until either map is modified, at which
+// point the modified map clones it.
+func (m *MapFunc[K, V]) Copy() *MapFunc[K, V] {
+	m.refs.Add(1)
+	m2 := new(MapFunc[K, V])
+	*m2 = *m
+	return m2
+}
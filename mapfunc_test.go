@@ -0,0 +1,132 @@
+// Copyright 2019 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an ISC-style
+// license that can be found in the LICENSE file.
+
+package hashmap
+
+import (
+	"bytes"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/zeebo/xxh3"
+)
+
+func TestMapFuncBytes(t *testing.T) {
+	m := NewFunc[[]byte, int](0, xxh3.Hash, bytes.Equal)
+	keys := rand.Perm(100000)
+	for i := 0; i < len(keys); i++ {
+		_, ok := m.Set([]byte(strconv.Itoa(keys[i])), keys[i])
+		if ok {
+			t.Fatalf("expected false")
+		}
+		if m.Len() != i+1 {
+			t.Fatalf("expected %d got %d", i+1, m.Len())
+		}
+	}
+	shuffle(keys)
+	for i := 0; i < len(keys); i++ {
+		v, ok := m.Get([]byte(strconv.Itoa(keys[i])))
+		if !ok || v != keys[i] {
+			t.Fatalf("expected %d got %d", keys[i], v)
+		}
+	}
+	shuffle(keys)
+	for i := 0; i < len(keys); i++ {
+		prev, ok := m.Set([]byte(strconv.Itoa(keys[i])), keys[i]*10)
+		if !ok || prev != keys[i] {
+			t.Fatalf("expected %d got %d", keys[i], prev)
+		}
+	}
+	if m.Len() != len(keys) {
+		t.Fatalf("expected %d got %d", len(keys), m.Len())
+	}
+	var n int
+	m.Scan(func(key []byte, value int) bool {
+		x, _ := strconv.Atoi(string(key))
+		if value != x*10 {
+			t.Fatalf("expected %d got %d", x*10, value)
+		}
+		n++
+		return true
+	})
+	if n != len(keys) {
+		t.Fatalf("expected %d got %d", len(keys), n)
+	}
+	m2 := m.Copy()
+	shuffle(keys)
+	for i := 0; i < len(keys); i++ {
+		v, ok := m.Delete([]byte(strconv.Itoa(keys[i])))
+		if !ok || v != keys[i]*10 {
+			t.Fatalf("expected %d got %d", keys[i]*10, v)
+		}
+		if m.Len() != len(keys)-i-1 {
+			t.Fatalf("expected %d got %d", len(keys)-i-1, m.Len())
+		}
+	}
+	if _, ok := m.Get([]byte("0")); ok {
+		t.Fatalf("expected false")
+	}
+	if m2.Len() != len(keys) || len(m2.Keys()) != len(keys) ||
+		len(m2.Values()) != len(keys) {
+		t.Fatalf("expected %d got %d", len(keys), m2.Len())
+	}
+}
+
+func TestMapFuncFold(t *testing.T) {
+	m := NewFunc[string, int](0,
+		func(key string) uint64 {
+			return xxh3.HashString(strings.ToLower(key))
+		},
+		strings.EqualFold,
+	)
+	m.Set("Hello", 1)
+	m.Set("WORLD", 2)
+	if _, ok := m.Set("hello", 3); !ok {
+		t.Fatalf("expected true")
+	}
+	if v, ok := m.Get("HELLO"); !ok || v != 3 {
+		t.Fatalf("expected %d got %d", 3, v)
+	}
+	if v, ok := m.Delete("World"); !ok || v != 2 {
+		t.Fatalf("expected %d got %d", 2, v)
+	}
+	keys := m.Keys()
+	sort.Strings(keys)
+	if len(keys) != 1 || keys[0] != "Hello" {
+		t.Fatalf("expected %v got %v", []string{"Hello"}, keys)
+	}
+}
+
+func TestMapFuncCopy(t *testing.T) {
+	m := NewFunc[[]byte, int](0, xxh3.Hash, bytes.Equal)
+	for i := 0; i < 1000; i++ {
+		m.Set([]byte(strconv.Itoa(i)), i)
+	}
+	m2 := m.Copy()
+	m3 := m.Copy()
+	for i := 0; i < 1000; i++ {
+		m.Set([]byte(strconv.Itoa(i)), -i)
+		if i%2 == 0 {
+			m2.Delete([]byte(strconv.Itoa(i)))
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		key := []byte(strconv.Itoa(i))
+		if v, _ := m.Get(key); v != -i {
+			t.Fatalf("expected %d got %d", -i, v)
+		}
+		if v, ok := m2.Get(key); ok != (i%2 == 1) || ok && v != i {
+			t.Fatalf("expected %d got %d", i, v)
+		}
+		if v, _ := m3.Get(key); v != i {
+			t.Fatalf("expected %d got %d", i, v)
+		}
+	}
+	if m.Len() != 1000 || m2.Len() != 500 || m3.Len() != 1000 {
+		t.Fatalf("expected %d got %d", 500, m2.Len())
+	}
+}
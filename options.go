@@ -0,0 +1,87 @@
+// Copyright 2019 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an ISC-style
+// license that can be found in the LICENSE file.
+
+package hashmap
+
+// Options for tuning the memory and performance trade-offs of a Map.
+// The zero value for each field uses the default.
+type Options struct {
+	// MaxLoadFactor is the fraction of buckets that can be used before the
+	// map grows. Lower values use more memory, but have shorter probe
+	// sequences. Must be above 0.5 and below 1. Default 0.85.
+	MaxLoadFactor float64
+	// MinLoadFactor is the fraction of buckets that are used when the map
+	// shrinks on deletes. Must be below the MaxLoadFactor divided by the
+	// GrowthFactor, so that a map that just grew does not shrink on the
+	// next delete. Default 0.15, or half of the MaxLoadFactor divided by
+	// the GrowthFactor when that is lower.
+	MinLoadFactor float64
+	// NoShrink disables automatically shrinking the map on deletes. The map
+	// can still be shrunk using ShrinkToFit.
+	NoShrink bool
+	// GrowthFactor is the multiplier for the number of buckets when the map
+	// grows. Must be a power of two. Default 2.
+	GrowthFactor int
+	// Incremental enables incremental resizing. Instead of moving all
+	// entries to a new bucket array at once, which can stall a Set or Delete
+	// on a large map, the old and new bucket arrays are kept together and
+	// each Set, Get, and Delete moves a few buckets at a time.
+	// While resizing, Get modifies the map and is not safe to call from
+	// multiple goroutines, and pointers returned by GetPtr, SetPtr, and Entry
+	// are only valid until the next call to the map.
+	Incremental bool
+}
+
+// defaultOptions are the options used by New.
+var defaultOptions = Options{
+	MaxLoadFactor: loadFactor,
+	MinLoadFactor: 1 - loadFactor,
+	GrowthFactor:  2,
+}
+
+// withDefaults returns a copy of the options with the defaults filled in.
+// Panics when an option is invalid.
+func (opts *Options) withDefaults() *Options {
+	o := defaultOptions
+	if opts != nil {
+		o.NoShrink = opts.NoShrink
+		o.Incremental = opts.Incremental
+		if opts.MaxLoadFactor != 0 {
+			o.MaxLoadFactor = opts.MaxLoadFactor
+		}
+		if opts.MinLoadFactor != 0 {
+			o.MinLoadFactor = opts.MinLoadFactor
+		}
+		if opts.GrowthFactor != 0 {
+			o.GrowthFactor = opts.GrowthFactor
+		}
+	}
+	// Robin hood hashing needs a load factor above 50% to be effective.
+	if !(o.MaxLoadFactor > 0.5 && o.MaxLoadFactor < 1) {
+		panic("hashmap: MaxLoadFactor must be above 0.5 and below 1")
+	}
+	if o.GrowthFactor < 2 || o.GrowthFactor&(o.GrowthFactor-1) != 0 {
+		panic("hashmap: GrowthFactor must be a power of two")
+	}
+	// A map that grows has a load of MaxLoadFactor/GrowthFactor, which must
+	// be above the MinLoadFactor.
+	grownLoad := o.MaxLoadFactor / float64(o.GrowthFactor)
+	if (opts == nil || opts.MinLoadFactor == 0) && o.MinLoadFactor >= grownLoad {
+		o.MinLoadFactor = grownLoad / 2
+	}
+	if !(o.MinLoadFactor >= 0 && o.MinLoadFactor < grownLoad) {
+		panic("hashmap: MinLoadFactor must be below MaxLoadFactor divided " +
+			"by GrowthFactor")
+	}
+	return &o
+}
+
+// NewWithOptions returns a new Map using the provided options.
+// Panics when an option is invalid.
+func NewWithOptions[K comparable, V any](cap int, opts *Options) *Map[K, V] {
+	m := new(Map[K, V])
+	m.opts = opts.withDefaults()
+	m.init(cap)
+	return m
+}
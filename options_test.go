@@ -0,0 +1,331 @@
+// Copyright 2019 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an ISC-style
+// license that can be found in the LICENSE file.
+
+package hashmap
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestOptions(t *testing.T) {
+	m := NewWithOptions[int, int](0, &Options{
+		MaxLoadFactor: 0.6,
+		MinLoadFactor: 0.125,
+		GrowthFactor:  4,
+	})
+	if m.growAt != 4 || m.shrinkAt != 1 {
+		t.Fatalf("expected %v/%v, got %v/%v", 4, 1, m.growAt, m.shrinkAt)
+	}
+	for i := 0; i < 5; i++ {
+		m.Set(i, i)
+	}
+	if len(m.buckets) != 32 {
+		t.Fatalf("expected %v, got %v", 32, len(m.buckets))
+	}
+	for i := 5; i < 10000; i++ {
+		m.Set(i, i)
+		if m.Len() > int(float64(len(m.buckets))*0.6) {
+			t.Fatalf("load factor exceeded")
+		}
+	}
+	checkDIBs(t, m)
+	for i := 0; i < 10000; i++ {
+		if v, ok := m.Delete(i); !ok || v != i {
+			t.Fatalf("expected %v, got %v", i, v)
+		}
+		if m.Len() > 8 && m.Len() < int(float64(len(m.buckets))*0.125) {
+			t.Fatalf("expected shrink")
+		}
+	}
+	if len(m.buckets) != 8 {
+		t.Fatalf("expected %v, got %v", 8, len(m.buckets))
+	}
+}
+
+func TestOptionsNoShrink(t *testing.T) {
+	m := NewWithOptions[int, int](0, &Options{NoShrink: true})
+	for i := 0; i < 10000; i++ {
+		m.Set(i, i)
+	}
+	nbuckets := len(m.buckets)
+	for i := 0; i < 10000; i++ {
+		m.Delete(i)
+	}
+	if len(m.buckets) != nbuckets {
+		t.Fatalf("expected %v, got %v", nbuckets, len(m.buckets))
+	}
+	m.ShrinkToFit()
+	if len(m.buckets) != 8 {
+		t.Fatalf("expected %v, got %v", 8, len(m.buckets))
+	}
+	s := NewSetWithOptions[int](0, &Options{NoShrink: true})
+	for i := 0; i < 10000; i++ {
+		s.Insert(i)
+	}
+	for i := 0; i < 10000; i++ {
+		s.Delete(i)
+	}
+	if len(s.base.buckets) != nbuckets {
+		t.Fatalf("expected %v, got %v", nbuckets, len(s.base.buckets))
+	}
+}
+
+func TestOptionsInvalid(t *testing.T) {
+	for _, opts := range []Options{
+		{MaxLoadFactor: 0.5},
+		{MaxLoadFactor: 1},
+		{MinLoadFactor: 0.5},
+		{MaxLoadFactor: 0.6, MinLoadFactor: 0.3},
+		{MinLoadFactor: -1},
+		{GrowthFactor: 1},
+		{GrowthFactor: 3},
+		{GrowthFactor: 8, MinLoadFactor: 0.15},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("expected panic for %+v", opts)
+				}
+			}()
+			NewWithOptions[int, int](0, &opts)
+		}()
+	}
+	// nil options are the defaults
+	m := NewWithOptions[int, int](0, nil)
+	if *m.opts != defaultOptions {
+		t.Fatalf("expected %v, got %v", defaultOptions, *m.opts)
+	}
+	// The default MinLoadFactor is lowered for a large GrowthFactor.
+	m = NewWithOptions[int, int](0, &Options{GrowthFactor: 8})
+	if m.opts.MinLoadFactor >= m.opts.MaxLoadFactor/8 {
+		t.Fatalf("expected below %v, got %v", m.opts.MaxLoadFactor/8,
+			m.opts.MinLoadFactor)
+	}
+}
+
+// countEntries returns the number of entries in the new and old buckets.
+func countEntries[K comparable, V any](m *Map[K, V]) int {
+	var n int
+	for _, buckets := range m.tables() {
+		for i := range buckets {
+			if buckets[i].dib() > 0 {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func TestOptionsIncremental(t *testing.T) {
+	m := NewWithOptions[int, int](0, &Options{Incremental: true})
+	m2 := make(map[int]int)
+	var resizes int
+	for i := 0; i < 100000; i++ {
+		key := rand.Intn(50000)
+		resizing := m.old != nil
+		oldLeft := m.oldLeft
+		step := m.moveStep()
+		switch rand.Intn(8) {
+		case 0, 1, 2:
+			prev, ok := m.Set(key, i)
+			prev2, ok2 := m2[key]
+			if ok != ok2 || prev != prev2 {
+				t.Fatalf("expected %v, got %v", prev2, prev)
+			}
+			m2[key] = i
+		case 3:
+			prev, ok := m.Delete(key)
+			prev2, ok2 := m2[key]
+			if ok != ok2 || prev != prev2 {
+				t.Fatalf("expected %v, got %v", prev2, prev)
+			}
+			delete(m2, key)
+		case 4:
+			m.Update(key, func(v int) int { return v + 1 })
+			m2[key]++
+		case 5:
+			if p := m.GetPtr(key); p != nil {
+				*p = -i
+				m2[key] = -i
+			}
+		default:
+			v, ok := m.Get(key)
+			v2, ok2 := m2[key]
+			if ok != ok2 || v != v2 {
+				t.Fatalf("expected %v, got %v", v2, v)
+			}
+		}
+		if !resizing && m.old != nil {
+			resizes++
+			// a resize just started and only a few buckets were moved
+			if m.oldLeft < len(m.old)-resizeStep {
+				t.Fatalf("expected at most %v buckets moved, got %v",
+					resizeStep, len(m.old)-m.oldLeft)
+			}
+		} else if resizing && m.old != nil && oldLeft-m.oldLeft > step {
+			t.Fatalf("expected at most %v buckets moved, got %v",
+				step, oldLeft-m.oldLeft)
+		}
+		if m.Len() != len(m2) {
+			t.Fatalf("expected %v, got %v", len(m2), m.Len())
+		}
+		if i%1000 == 0 {
+			if countEntries(m) != len(m2) {
+				t.Fatalf("expected %v, got %v", len(m2), countEntries(m))
+			}
+			m3 := m.Copy()
+			var n int
+			m3.Scan(func(key, value int) bool {
+				if value != m2[key] {
+					t.Fatalf("expected %v, got %v", m2[key], value)
+				}
+				n++
+				return true
+			})
+			if n != len(m2) {
+				t.Fatalf("expected %v, got %v", len(m2), n)
+			}
+		}
+	}
+	if resizes == 0 {
+		t.Fatal("expected resizes")
+	}
+	for key, value := range m2 {
+		if v, ok := m.Get(key); !ok || v != value {
+			t.Fatalf("expected %v, got %v", value, v)
+		}
+	}
+	// delete everything, which shrinks the map
+	for key := range m2 {
+		if _, ok := m.Delete(key); !ok {
+			t.Fatal("expected true")
+		}
+	}
+	m.ShrinkToFit()
+	if m.Len() != 0 || countEntries(m) != 0 {
+		t.Fatalf("expected empty")
+	}
+}
+
+func TestOptionsThrash(t *testing.T) {
+	for _, opts := range []*Options{
+		nil,
+		{GrowthFactor: 4},
+		{GrowthFactor: 8},
+		{GrowthFactor: 16, MaxLoadFactor: 0.6},
+		{MaxLoadFactor: 0.9, MinLoadFactor: 0.4},
+	} {
+		for _, n := range []int{6, 100, 1000, 10000} {
+			m := NewWithOptions[int, int](0, opts)
+			for i := 0; i < n; i++ {
+				m.Set(i, i)
+			}
+			// Fill to the boundary, where the next new key grows the map.
+			for i := n; m.Len() < m.growAt; i++ {
+				m.Set(i, i)
+			}
+			// resizes counts the changes to the number of buckets.
+			var resizes int
+			nbuckets := len(m.buckets)
+			check := func() {
+				if len(m.buckets) != nbuckets {
+					nbuckets = len(m.buckets)
+					resizes++
+				}
+			}
+			for i := 0; i < 100; i++ {
+				m.Set(-1, -1)
+				check()
+				m.Delete(-1)
+				check()
+			}
+			if resizes > 1 {
+				t.Fatalf("%+v, %v: expected at most %v resize, got %v",
+					opts, n, 1, resizes)
+			}
+			// Empty down to the boundary, where the next delete shrinks the
+			// map.
+			for i := 0; m.Len() > m.shrinkAt+1; i++ {
+				m.Delete(i)
+			}
+			key := m.Keys()[0]
+			resizes, nbuckets = 0, len(m.buckets)
+			for i := 0; i < 100; i++ {
+				m.Delete(key)
+				check()
+				m.Set(key, 0)
+				check()
+			}
+			if resizes > 1 {
+				t.Fatalf("%+v, %v: expected at most %v resize, got %v",
+					opts, n, 1, resizes)
+			}
+		}
+	}
+}
+
+func TestOptionsSetExisting(t *testing.T) {
+	for _, opts := range []*Options{nil, {Incremental: true},
+		{GrowthFactor: 4}} {
+		m := NewWithOptions[int, int](0, opts)
+		// Exactly at the point where the next new key grows the map.
+		for i := 0; m.Len() < m.growAt; i++ {
+			m.Set(i, i)
+		}
+		if m.old != nil {
+			m.moveAll()
+		}
+		nbuckets := len(m.buckets)
+		for i := 0; i < m.Len(); i++ {
+			if prev, ok := m.Set(i, -i); !ok || prev != i {
+				t.Fatalf("expected %v, got %v", i, prev)
+			}
+		}
+		if len(m.buckets) != nbuckets || m.old != nil {
+			t.Fatalf("expected %v, got %v", nbuckets, len(m.buckets))
+		}
+		m.Set(-1, -1)
+		if len(m.buckets) == nbuckets {
+			t.Fatal("expected grow")
+		}
+	}
+}
+
+func TestOptionsIncrementalStall(t *testing.T) {
+	m := NewWithOptions[int, int](0, &Options{
+		Incremental:   true,
+		MaxLoadFactor: 0.9,
+		MinLoadFactor: 0.4,
+	})
+	// maxMoved is the most buckets moved by a single call.
+	var maxMoved int
+	do := func(op func()) {
+		old, oldLeft := m.old, m.oldLeft
+		op()
+		moved := oldLeft
+		if old != nil && m.old != nil && &m.old[0] == &old[0] {
+			moved = oldLeft - m.oldLeft
+		}
+		maxMoved = max(maxMoved, moved)
+	}
+	var n int
+	for len(m.buckets) < 1<<17 || m.old != nil {
+		do(func() { m.Set(n, n) })
+		n++
+	}
+	// Delete until the map shrinks, and then add keys until it grows.
+	nbuckets := len(m.buckets)
+	for i := 0; len(m.buckets) == nbuckets; i++ {
+		do(func() { m.Delete(i) })
+	}
+	nbuckets = len(m.buckets)
+	for len(m.buckets) == nbuckets || m.old != nil {
+		do(func() { m.Set(n, n) })
+		n++
+	}
+	if maxMoved > 64 {
+		t.Fatalf("expected at most %v buckets moved, got %v", 64, maxMoved)
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2019 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an ISC-style
+// license that can be found in the LICENSE file.
+
+package hashmap
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+	"os"
+	"reflect"
+	"unsafe"
+)
+
+// ReadOnlyMap is a frozen map that is loaded from a file that was written
+// using Map.WriteTo. The file is memory-mapped, where supported, and the
+// buckets are used as they are, so opening a map is fast no matter how
+// large it is, and only the parts of the file that are accessed are read.
+//
+// The keys and values must be fixed-size types, such as integers, or arrays
+// and structs of them, and the map must use the default hasher.
+//
+// A ReadOnlyMap is safe for concurrent use by multiple goroutines.
+type ReadOnlyMap[K comparable, V any] struct {
+	cfg     Map[K, V] // hashing config, has no buckets
+	buckets []entry[K, V]
+	mask    int
+	length  int
+	data    []byte // the mapped file
+}
+
+// OpenReadOnly opens a ReadOnlyMap from a file.
+// The map must be closed when no longer needed.
+func OpenReadOnly[K comparable, V any](name string) (*ReadOnlyMap[K, V], error) {
+	var k K
+	var v V
+	if !isFixed(reflect.TypeOf(&k).Elem()) ||
+		!isFixed(reflect.TypeOf(&v).Elem()) {
+		return nil, fmt.Errorf("hashmap: read-only map needs fixed-size "+
+			"types, not %T and %T", k, v)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	data, err := mmapFile(f, fi.Size())
+	if err != nil {
+		return nil, err
+	}
+	m := &ReadOnlyMap[K, V]{data: data}
+	if err := m.load(); err != nil {
+		munmapFile(data)
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *ReadOnlyMap[K, V]) load() error {
+	var h binHeader
+	if err := h.decode(m.data); err != nil {
+		return err
+	}
+	if err := h.checkTypes(fixedSize(reflect.TypeOf((*K)(nil)).Elem()),
+		fixedSize(reflect.TypeOf((*V)(nil)).Elem()),
+		typesFingerprint[K, V]()); err != nil {
+		return err
+	}
+	if h.flags&binFlagHasher != 0 {
+		return errors.New("hashmap: read-only map needs the default hasher")
+	}
+	m.cfg.seed = h.seed
+	m.cfg.detectHasher()
+	if h.flags&binFlagRaw == 0 {
+		if h.length != 0 {
+			return errors.New("hashmap: binary data has no raw buckets")
+		}
+		return nil
+	}
+	n := h.nbuckets
+	body := uint64(len(m.data) - binHeaderSize)
+	if h.esize != uint32(unsafe.Sizeof(entry[K, V]{})) || n == 0 ||
+		n&(n-1) != 0 || n > body/uint64(h.esize) ||
+		body != n*uint64(h.esize) || h.length >= n {
+		return errBinaryFormat
+	}
+	m.buckets = unsafe.Slice(
+		(*entry[K, V])(unsafe.Pointer(&m.data[binHeaderSize])), n)
+	m.mask = int(n - 1)
+	m.length = int(h.length)
+	return nil
+}
+
+// Close closes the map. It must not be used afterwards.
+func (m *ReadOnlyMap[K, V]) Close() error {
+	data := m.data
+	*m = ReadOnlyMap[K, V]{}
+	if data == nil {
+		return nil
+	}
+	return munmapFile(data)
+}
+
+// Len returns the number of values in map.
+func (m *ReadOnlyMap[K, V]) Len() int {
+	return m.length
+}
+
+// Get returns a value for a key.
+// Returns false when no value has been assign for key.
+func (m *ReadOnlyMap[K, V]) Get(key K) (value V, ok bool) {
+	if m.length == 0 {
+		return value, false
+	}
+	hash := m.cfg.hash(key)
+	i := hash & m.mask
+	// The number of probes is limited, because the file is not validated
+	// and could be missing an empty bucket.
+	for n := 0; n < len(m.buckets); n++ {
+		if m.buckets[i].dib() == 0 {
+			return value, false
+		}
+		if m.buckets[i].hash() == hash && m.buckets[i].key == key {
+			return m.buckets[i].value, true
+		}
+		i = (i + 1) & m.mask
+	}
+	return value, false
+}
+
+// Scan iterates over all key/values.
+func (m *ReadOnlyMap[K, V]) Scan(iter func(key K, value V) bool) {
+	for i := 0; i < len(m.buckets); i++ {
+		if m.buckets[i].dib() > 0 {
+			if !iter(m.buckets[i].key, m.buckets[i].value) {
+				return
+			}
+		}
+	}
+}
+
+// All returns an iterator over all key/values.
+func (m *ReadOnlyMap[K, V]) All() iter.Seq2[K, V] {
+	return m.Scan
+}
@@ -0,0 +1,103 @@
+// Copyright 2019 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an ISC-style
+// license that can be found in the LICENSE file.
+
+package hashmap
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeMapFile[K comparable, V any](t *testing.T, m *Map[K, V]) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "map.bin")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := m.WriteTo(f); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestReadOnly(t *testing.T) {
+	for _, opts := range []*Options{nil, {Incremental: true}} {
+		m := NewWithOptions[uint64, point](0, opts)
+		for i := 0; i < 10000; i++ {
+			m.Set(uint64(i), point{int32(i), 0, 1})
+		}
+		name := writeMapFile(t, m)
+		ro, err := OpenReadOnly[uint64, point](name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ro.Len() != 10000 {
+			t.Fatalf("expected %v, got %v", 10000, ro.Len())
+		}
+		for i := 0; i < 10000; i++ {
+			v, ok := ro.Get(uint64(i))
+			if !ok || v.X != int32(i) {
+				t.Fatalf("expected %v, got %v", i, v)
+			}
+		}
+		if _, ok := ro.Get(10000); ok {
+			t.Fatal("expected false")
+		}
+		var n int
+		for range ro.All() {
+			n++
+		}
+		if n != 10000 {
+			t.Fatalf("expected %v, got %v", 10000, n)
+		}
+		if err := ro.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := ro.Get(1); ok {
+			t.Fatal("expected false")
+		}
+	}
+}
+
+func TestReadOnlyInvalid(t *testing.T) {
+	// empty map
+	ro, err := OpenReadOnly[int, int](writeMapFile(t, new(Map[int, int])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ro.Get(1); ok || ro.Len() != 0 {
+		t.Fatal("expected empty")
+	}
+	ro.Close()
+
+	m := New[int64, int64](0)
+	m.Set(1, 1)
+	name := writeMapFile(t, m)
+	if _, err := OpenReadOnly[int64, int32](name); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := OpenReadOnly[string, int64](name); err == nil {
+		t.Fatal("expected error")
+	}
+	// types with the same size
+	if _, err := OpenReadOnly[int64, float64](name); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := OpenReadOnly[uint64, int64](name); err == nil {
+		t.Fatal("expected error")
+	}
+	data, _ := os.ReadFile(name)
+	os.WriteFile(name, data[:len(data)-1], 0666)
+	if _, err := OpenReadOnly[int64, int64](name); err == nil {
+		t.Fatal("expected error")
+	}
+	m2 := NewWithHasher[int, int](0, func(key int) uint64 { return 0 })
+	m2.Set(1, 1)
+	if _, err := OpenReadOnly[int, int](writeMapFile(t, m2)); err == nil {
+		t.Fatal("expected error")
+	}
+}
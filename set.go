@@ -1,9 +1,42 @@
 package hashmap
 
+import (
+	"iter"
+	"math/rand"
+)
+
 type Set[K comparable] struct {
 	base Map[K, struct{}]
 }
 
+// NewSetWithHasher returns a new Set that uses the provided hash function
+// for hashing keys, instead of the default xxh3 hasher.
+// Keys that are equal must always produce the same hash.
+func NewSetWithHasher[K comparable](cap int, hash func(key K) uint64) *Set[K] {
+	s := new(Set[K])
+	s.base.hasher = hash
+	s.base.init(cap)
+	return s
+}
+
+// NewSetWithOptions returns a new Set using the provided options.
+// Panics when an option is invalid.
+func NewSetWithOptions[K comparable](cap int, opts *Options) *Set[K] {
+	s := new(Set[K])
+	s.base.opts = opts.withDefaults()
+	s.base.init(cap)
+	return s
+}
+
+// NewSetWithSeed returns a new Set that uses the provided seed for hashing
+// keys, instead of a random one.
+func NewSetWithSeed[K comparable](cap int, seed uint64) *Set[K] {
+	s := new(Set[K])
+	s.base.init(cap)
+	s.base.seed = seed
+	return s
+}
+
 // Insert an item
 func (tr *Set[K]) Insert(key K) {
 	tr.base.Set(key, struct{}{})
@@ -25,17 +58,67 @@ func (tr *Set[K]) Delete(key K) {
 	tr.base.Delete(key)
 }
 
+// DeleteFunc deletes the keys for which del returns true, in a single pass
+// over the set. The del function must not change the set.
+// Returns the number of keys that were deleted.
+func (tr *Set[K]) DeleteFunc(del func(key K) bool) int {
+	return tr.base.DeleteFunc(func(key K, _ struct{}) bool {
+		return del(key)
+	})
+}
+
+// Retain deletes the keys for which keep returns false.
+// Returns the number of keys that were deleted.
+func (tr *Set[K]) Retain(keep func(key K) bool) int {
+	return tr.base.DeleteFunc(func(key K, _ struct{}) bool {
+		return !keep(key)
+	})
+}
+
 func (tr *Set[K]) Scan(iter func(key K) bool) {
 	tr.base.Scan(func(key K, value struct{}) bool {
 		return iter(key)
 	})
 }
 
+// Clear removes all items.
+// The bucket array is retained so it can be reused without allocating. Call
+// ShrinkToFit afterwards to release its memory.
+func (tr *Set[K]) Clear() {
+	tr.base.Clear()
+}
+
+// Reserve grows the set, when needed, so that it can hold at least n more
+// items without resizing. Useful before bulk loading.
+func (tr *Set[K]) Reserve(n int) {
+	tr.base.Reserve(n)
+}
+
+// ShrinkToFit shrinks the set to the smallest size that holds its items.
+func (tr *Set[K]) ShrinkToFit() {
+	tr.base.ShrinkToFit()
+}
+
 // Keys returns all keys as a slice
 func (tr *Set[K]) Keys() []K {
 	return tr.base.Keys()
 }
 
+// All returns an iterator over all keys.
+// It's not safe to call or Insert or Delete while iterating.
+func (tr *Set[K]) All() iter.Seq[K] {
+	return tr.base.KeySeq()
+}
+
+// CollectSet returns a new Set containing all keys from seq.
+func CollectSet[K comparable](seq iter.Seq[K]) *Set[K] {
+	s := new(Set[K])
+	for key := range seq {
+		s.Insert(key)
+	}
+	return s
+}
+
 // Copy the set. This is a copy-on-write operation and is very fast because
 // it only performs a shadow copy.
 func (tr *Set[K]) Copy() *Set[K] {
@@ -44,6 +127,29 @@ func (tr *Set[K]) Copy() *Set[K] {
 	return tr2
 }
 
+// ScanCursor iterates over the set in batches, like the Redis SCAN command.
+// See Map.ScanCursor for more information.
+func (s *Set[K]) ScanCursor(cursor uint64, count int) (keys []K, next uint64) {
+	keys, _, next = s.base.ScanCursor(cursor, count)
+	return keys, next
+}
+
+// Random returns a random key. Every key is equally likely to be returned.
+// A nil rng uses the default source of the math/rand package.
+// Returns false when the set is empty.
+func (tr *Set[K]) Random(rng *rand.Rand) (key K, ok bool) {
+	key, _, ok = tr.base.Random(rng)
+	return key, ok
+}
+
+// SampleN returns up to n random keys, without returning the same key more
+// than once. Every key is equally likely to be returned.
+// A nil rng uses the default source of the math/rand package.
+func (tr *Set[K]) SampleN(rng *rand.Rand, n int) []K {
+	keys, _ := tr.base.SampleN(rng, n)
+	return keys
+}
+
 // GetPos gets a single keys/value nearby a position.
 // The pos param can be any valid uint64. Useful for grabbing a random item
 // from the Set.
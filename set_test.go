@@ -165,3 +165,169 @@ func TestSetGetPos(t *testing.T) {
 		t.Fatal()
 	}
 }
+
+func TestSetHasher(t *testing.T) {
+	var calls int
+	s := NewSetWithHasher(0, func(key int) uint64 {
+		calls++
+		return uint64(key) << 16
+	})
+	for i := 0; i < 1000; i++ {
+		s.Insert(i)
+	}
+	for i := 0; i < 1000; i++ {
+		if !s.Contains(i) {
+			t.Fatalf("expected true")
+		}
+	}
+	if s.Contains(1000) {
+		t.Fatalf("expected false")
+	}
+	if calls != 2001 {
+		t.Fatalf("expected %d got %d", 2001, calls)
+	}
+}
+
+func TestSetSeed(t *testing.T) {
+	s1 := NewSetWithSeed[int](0, 99)
+	s2 := NewSetWithSeed[int](0, 99)
+	for i := 0; i < 1000; i++ {
+		s1.Insert(i)
+		s2.Insert(i)
+	}
+	if !reflect.DeepEqual(s1.Keys(), s2.Keys()) {
+		t.Fatal("expected Keys equal")
+	}
+}
+
+func TestSetIterators(t *testing.T) {
+	var s Set[int]
+	for i := 0; i < 1000; i++ {
+		s.Insert(i)
+	}
+	var keys []int
+	for key := range s.All() {
+		keys = append(keys, key)
+	}
+	if !reflect.DeepEqual(keys, s.Keys()) {
+		t.Fatal("expected Keys equal")
+	}
+	s2 := CollectSet(s.All())
+	if s2.Len() != s.Len() {
+		t.Fatalf("expected %d got %d", s.Len(), s2.Len())
+	}
+	for key := range s.All() {
+		if !s2.Contains(key) {
+			t.Fatalf("expected true")
+		}
+	}
+}
+
+func TestSetCapacity(t *testing.T) {
+	var s Set[int]
+	s.Reserve(1000)
+	for i := 0; i < 1000; i++ {
+		s.Insert(i)
+	}
+	s.Clear()
+	if s.Len() != 0 || s.Contains(1) {
+		t.Fatalf("expected empty")
+	}
+	s.Insert(1)
+	s.ShrinkToFit()
+	if s.Len() != 1 || !s.Contains(1) {
+		t.Fatalf("expected true")
+	}
+}
+
+func TestSetAlgebra(t *testing.T) {
+	makeSet := func(seed bool, keys ...int) *Set[int] {
+		var s *Set[int]
+		if seed {
+			s = NewSetWithSeed[int](0, 1)
+		} else {
+			s = new(Set[int])
+		}
+		for _, key := range keys {
+			s.Insert(key)
+		}
+		return s
+	}
+	rng := func(start, end int) []int {
+		var keys []int
+		for i := start; i < end; i++ {
+			keys = append(keys, i)
+		}
+		return keys
+	}
+	check := func(s *Set[int], keys []int) {
+		t.Helper()
+		if !s.Equal(makeSet(false, keys...)) {
+			t.Fatalf("expected %v keys, got %v", len(keys), s.Len())
+		}
+	}
+	// both with the same seed, and each with a random seed
+	for _, seed := range []bool{true, false} {
+		for _, sizes := range [][2]int{{100, 1000}, {1000, 100}} {
+			a := makeSet(seed, rng(0, sizes[0])...)
+			b := makeSet(seed, rng(50, 50+sizes[1])...)
+			union := rng(0, max(sizes[0], 50+sizes[1]))
+			inter := rng(50, min(sizes[0], 50+sizes[1]))
+			diff := rng(0, 50)
+			if sizes[0] > 50+sizes[1] {
+				diff = append(diff, rng(50+sizes[1], sizes[0])...)
+			}
+			symm := append(append([]int(nil), diff...),
+				rng(sizes[0], 50+sizes[1])...)
+			check(a.Union(b), union)
+			check(a.Intersect(b), inter)
+			check(a.Difference(b), diff)
+			check(a.SymmetricDifference(b), symm)
+			// the operands are not modified
+			check(a, rng(0, sizes[0]))
+			check(b, rng(50, 50+sizes[1]))
+			for _, op := range []struct {
+				fn   func(a, b *Set[int])
+				keys []int
+			}{
+				{(*Set[int]).UnionWith, union},
+				{(*Set[int]).IntersectWith, inter},
+				{(*Set[int]).DifferenceWith, diff},
+				{(*Set[int]).SymmetricDifferenceWith, symm},
+			} {
+				c := a.Copy()
+				op.fn(c, b)
+				check(c, op.keys)
+				check(a, rng(0, sizes[0]))
+			}
+			if a.IsSubset(b) || a.IsDisjoint(b) || a.Equal(b) {
+				t.Fatal("expected false")
+			}
+			if a.IsSuperset(b) != (sizes[0] >= 50+sizes[1]) {
+				t.Fatalf("expected %v", !a.IsSuperset(b))
+			}
+			i := a.Intersect(b)
+			if !i.IsSubset(a) || !i.IsSubset(b) || !a.IsSuperset(i) ||
+				!i.IsDisjoint(a.Difference(b)) {
+				t.Fatal("expected true")
+			}
+		}
+	}
+	// with itself
+	a := makeSet(false, rng(0, 100)...)
+	if !a.Equal(a) || !a.IsSubset(a) || a.IsDisjoint(a) ||
+		a.Difference(a).Len() != 0 || a.Intersect(a).Len() != 100 {
+		t.Fatal("unexpected result")
+	}
+	a.SymmetricDifferenceWith(a)
+	if a.Len() != 0 || !a.IsDisjoint(a) {
+		t.Fatal("expected empty")
+	}
+	// with empty sets
+	var e1, e2 Set[int]
+	check(e1.Union(&e2), nil)
+	e1.UnionWith(makeSet(false, 1, 2))
+	check(&e1, []int{1, 2})
+	check(e2.Intersect(&e1), nil)
+	check(e2.Difference(&e1), nil)
+}
@@ -0,0 +1,260 @@
+// Copyright 2019 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an ISC-style
+// license that can be found in the LICENSE file.
+
+package hashmap
+
+// Set operations iterate over the smaller set and look up its keys in the
+// larger set. When both sets use the default hasher with the same seed, such
+// as a set and its copy, the hashes stored in the buckets are used instead
+// of hashing the keys again.
+
+type setEntry[K comparable] = entry[K, struct{}]
+
+// sameHashes returns true when a key has the same hash in both sets.
+func (tr *Set[K]) sameHashes(other *Set[K]) bool {
+	return tr == other || (tr.base.hasher == nil &&
+		other.base.hasher == nil && tr.base.seed == other.base.seed)
+}
+
+// hashOf returns the hash in tr for an entry from other.
+func (tr *Set[K]) hashOf(other *Set[K], e *setEntry[K]) int {
+	if tr.sameHashes(other) {
+		return e.hash()
+	}
+	return tr.base.hash(e.key)
+}
+
+// each calls fn for each entry.
+func (tr *Set[K]) each(fn func(e *setEntry[K]) bool) {
+	for _, buckets := range tr.base.tables() {
+		for i := range buckets {
+			if buckets[i].dib() > 0 && !fn(&buckets[i]) {
+				return
+			}
+		}
+	}
+}
+
+// has returns true when an entry from other is in tr.
+func (tr *Set[K]) has(other *Set[K], e *setEntry[K]) bool {
+	if tr.base.length == 0 {
+		return false
+	}
+	_, ok := tr.base.getHashed(tr.hashOf(other, e), e.key)
+	return ok
+}
+
+// insert inserts an entry from other into tr.
+func (tr *Set[K]) insert(other *Set[K], e *setEntry[K]) {
+	if len(tr.base.buckets) == 0 {
+		tr.base.init(0)
+	}
+	tr.base.setHashed(tr.hashOf(other, e), e.key, struct{}{})
+}
+
+// remove deletes an entry from other from tr.
+func (tr *Set[K]) remove(other *Set[K], e *setEntry[K]) {
+	if tr.base.length > 0 {
+		tr.base.deleteHashed(tr.hashOf(other, e), e.key)
+	}
+}
+
+// newLike returns an empty set that hashes keys like tr.
+func (tr *Set[K]) newLike() *Set[K] {
+	s := new(Set[K])
+	s.base.hasher = tr.base.hasher
+	s.base.opts = tr.base.opts
+	s.base.init(0)
+	if len(tr.base.buckets) > 0 {
+		s.base.seed = tr.base.seed
+	}
+	return s
+}
+
+// smaller returns the smaller and the larger of two sets.
+func smaller[K comparable](a, b *Set[K]) (*Set[K], *Set[K]) {
+	if b.Len() < a.Len() {
+		return b, a
+	}
+	return a, b
+}
+
+// Union returns a new set with the keys that are in either set.
+func (tr *Set[K]) Union(other *Set[K]) *Set[K] {
+	small, large := smaller(tr, other)
+	s := large.Copy()
+	s.UnionWith(small)
+	return s
+}
+
+// UnionWith inserts the keys of other into the set.
+func (tr *Set[K]) UnionWith(other *Set[K]) {
+	if other == tr {
+		return
+	}
+	other.each(func(e *setEntry[K]) bool {
+		tr.insert(other, e)
+		return true
+	})
+}
+
+// Intersect returns a new set with the keys that are in both sets.
+func (tr *Set[K]) Intersect(other *Set[K]) *Set[K] {
+	if other == tr {
+		return tr.Copy()
+	}
+	small, large := smaller(tr, other)
+	s := small.newLike()
+	small.each(func(e *setEntry[K]) bool {
+		if large.has(small, e) {
+			s.insert(small, e)
+		}
+		return true
+	})
+	return s
+}
+
+// IntersectWith deletes the keys that are not in other from the set.
+func (tr *Set[K]) IntersectWith(other *Set[K]) {
+	if other == tr {
+		return
+	}
+	var entries []setEntry[K]
+	if other.Len() < tr.Len() {
+		// Collect the keys to keep.
+		other.each(func(e *setEntry[K]) bool {
+			if tr.has(other, e) {
+				entries = append(entries, *e)
+			}
+			return true
+		})
+		tr.Clear()
+		for i := range entries {
+			tr.insert(other, &entries[i])
+		}
+		return
+	}
+	// Collect the keys to delete.
+	tr.each(func(e *setEntry[K]) bool {
+		if !other.has(tr, e) {
+			entries = append(entries, *e)
+		}
+		return true
+	})
+	for i := range entries {
+		tr.remove(tr, &entries[i])
+	}
+}
+
+// Difference returns a new set with the keys that are in the set but not in
+// other.
+func (tr *Set[K]) Difference(other *Set[K]) *Set[K] {
+	if other == tr {
+		return tr.newLike()
+	}
+	if other.Len() < tr.Len() {
+		s := tr.Copy()
+		s.DifferenceWith(other)
+		return s
+	}
+	s := tr.newLike()
+	tr.each(func(e *setEntry[K]) bool {
+		if !other.has(tr, e) {
+			s.insert(tr, e)
+		}
+		return true
+	})
+	return s
+}
+
+// DifferenceWith deletes the keys that are in other from the set.
+func (tr *Set[K]) DifferenceWith(other *Set[K]) {
+	if other == tr {
+		tr.Clear()
+		return
+	}
+	if other.Len() <= tr.Len() {
+		other.each(func(e *setEntry[K]) bool {
+			tr.remove(other, e)
+			return true
+		})
+		return
+	}
+	var entries []setEntry[K]
+	tr.each(func(e *setEntry[K]) bool {
+		if other.has(tr, e) {
+			entries = append(entries, *e)
+		}
+		return true
+	})
+	for i := range entries {
+		tr.remove(tr, &entries[i])
+	}
+}
+
+// SymmetricDifference returns a new set with the keys that are in one of
+// the sets, but not in both.
+func (tr *Set[K]) SymmetricDifference(other *Set[K]) *Set[K] {
+	small, large := smaller(tr, other)
+	s := large.Copy()
+	s.SymmetricDifferenceWith(small)
+	return s
+}
+
+// SymmetricDifferenceWith deletes the keys that are in other from the set,
+// and inserts the keys of other that are not in the set.
+func (tr *Set[K]) SymmetricDifferenceWith(other *Set[K]) {
+	if other == tr {
+		tr.Clear()
+		return
+	}
+	other.each(func(e *setEntry[K]) bool {
+		if tr.has(other, e) {
+			tr.remove(other, e)
+		} else {
+			tr.insert(other, e)
+		}
+		return true
+	})
+}
+
+// IsSubset returns true when every key in the set is in other.
+func (tr *Set[K]) IsSubset(other *Set[K]) bool {
+	if other == tr {
+		return true
+	}
+	if tr.Len() > other.Len() {
+		return false
+	}
+	subset := true
+	tr.each(func(e *setEntry[K]) bool {
+		subset = other.has(tr, e)
+		return subset
+	})
+	return subset
+}
+
+// IsSuperset returns true when every key in other is in the set.
+func (tr *Set[K]) IsSuperset(other *Set[K]) bool {
+	return other.IsSubset(tr)
+}
+
+// IsDisjoint returns true when the sets have no keys in common.
+func (tr *Set[K]) IsDisjoint(other *Set[K]) bool {
+	if other == tr {
+		return tr.Len() == 0
+	}
+	small, large := smaller(tr, other)
+	disjoint := true
+	small.each(func(e *setEntry[K]) bool {
+		disjoint = !large.has(small, e)
+		return disjoint
+	})
+	return disjoint
+}
+
+// Equal returns true when both sets have the same keys.
+func (tr *Set[K]) Equal(other *Set[K]) bool {
+	return tr.Len() == other.Len() && tr.IsSubset(other)
+}
@@ -0,0 +1,92 @@
+// Copyright 2019 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an ISC-style
+// license that can be found in the LICENSE file.
+
+package hashmap
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// SyncMap is a hashmap for read-mostly data, such as configuration and
+// routing tables, that is safe for concurrent use by multiple goroutines.
+//
+// Readers load the current Map from an atomic pointer and never block, and
+// a published Map is never modified. Writers are serialized, and each write
+// copies the current Map, modifies the copy, and publishes it for new
+// readers. Use Update to batch many writes into a single copy.
+//
+// The zero value is an empty map ready to use.
+type SyncMap[K comparable, V any] struct {
+	mu sync.Mutex // serializes writers
+	m  atomic.Pointer[Map[K, V]]
+}
+
+// Get returns a value for a key.
+// Returns false when no value has been assign for key.
+func (s *SyncMap[K, V]) Get(key K) (value V, ok bool) {
+	m := s.m.Load()
+	if m == nil {
+		return value, false
+	}
+	return m.Get(key)
+}
+
+// Len returns the number of values in map.
+func (s *SyncMap[K, V]) Len() int {
+	m := s.m.Load()
+	if m == nil {
+		return 0
+	}
+	return m.Len()
+}
+
+// Range iterates over all key/values of the current map. Writes that are
+// published while ranging are not seen, and it's safe to call any method,
+// including Set and Delete, from fn.
+func (s *SyncMap[K, V]) Range(fn func(key K, value V) bool) {
+	m := s.m.Load()
+	if m == nil {
+		return
+	}
+	m.Scan(fn)
+}
+
+// Set assigns a value to a key.
+// Returns the previous value, or false when no value was assigned.
+func (s *SyncMap[K, V]) Set(key K, value V) (prev V, ok bool) {
+	s.Update(func(m *Map[K, V]) {
+		prev, ok = m.Set(key, value)
+	})
+	return prev, ok
+}
+
+// Delete deletes a value for a key.
+// Returns the deleted value, or false when no value was assigned.
+func (s *SyncMap[K, V]) Delete(key K) (prev V, deleted bool) {
+	if _, ok := s.Get(key); !ok {
+		return prev, false
+	}
+	s.Update(func(m *Map[K, V]) {
+		prev, deleted = m.Delete(key)
+	})
+	return prev, deleted
+}
+
+// Update calls fn with a copy of the current map, and then publishes the
+// copy, with all of the changes that fn made, for new readers.
+// Other writers are blocked while fn is called. The map must not be
+// retained after fn returns.
+func (s *SyncMap[K, V]) Update(fn func(m *Map[K, V])) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var m *Map[K, V]
+	if cur := s.m.Load(); cur != nil {
+		m = cur.Copy()
+	} else {
+		m = new(Map[K, V])
+	}
+	fn(m)
+	s.m.Store(m)
+}
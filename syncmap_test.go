@@ -0,0 +1,192 @@
+// Copyright 2019 Joshua J Baker. All rights reserved.
+// Use of this source code is governed by an ISC-style
+// license that can be found in the LICENSE file.
+
+package hashmap
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSyncMap(t *testing.T) {
+	var s SyncMap[int, int]
+	if _, ok := s.Get(1); ok {
+		t.Fatal("expected false")
+	}
+	if _, ok := s.Delete(1); ok {
+		t.Fatal("expected false")
+	}
+	const N = 1000
+	var done atomic.Bool
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !done.Load() {
+				// keys are always published in order
+				n := s.Len()
+				for i := 0; i < n; i++ {
+					if v, ok := s.Get(i); !ok || v != i {
+						t.Errorf("expected %v, got %v", i, v)
+						return
+					}
+				}
+			}
+		}()
+	}
+	for i := 0; i < N; i++ {
+		if _, ok := s.Set(i, i); ok {
+			t.Fatal("expected false")
+		}
+	}
+	s.Update(func(m *Map[int, int]) {
+		for i := N; i < N*2; i++ {
+			m.Set(i, i)
+		}
+	})
+	done.Store(true)
+	wg.Wait()
+	if s.Len() != N*2 {
+		t.Fatalf("expected %v, got %v", N*2, s.Len())
+	}
+	var n int
+	s.Range(func(key, value int) bool {
+		if key%2 == 0 {
+			if prev, ok := s.Delete(key); !ok || prev != key {
+				t.Fatalf("expected %v, got %v", key, prev)
+			}
+		}
+		n++
+		return true
+	})
+	if n != N*2 {
+		t.Fatalf("expected %v, got %v", N*2, n)
+	}
+	if s.Len() != N {
+		t.Fatalf("expected %v, got %v", N, s.Len())
+	}
+}
+
+type mutexMap[K comparable, V any] struct {
+	mu sync.RWMutex
+	m  Map[K, V]
+}
+
+func (m *mutexMap[K, V]) Get(key K) (V, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.m.Get(key)
+}
+
+func (m *mutexMap[K, V]) Set(key K, value V) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.m.Set(key, value)
+}
+
+const benchSyncN = 1000
+
+func BenchmarkSyncMapGet(b *testing.B) {
+	var s SyncMap[int, int]
+	s.Update(func(m *Map[int, int]) {
+		for i := 0; i < benchSyncN; i++ {
+			m.Set(i, i)
+		}
+	})
+	b.RunParallel(func(pb *testing.PB) {
+		var i int
+		for pb.Next() {
+			s.Get(i % benchSyncN)
+			i++
+		}
+	})
+}
+
+func BenchmarkStdSyncMapGet(b *testing.B) {
+	var s sync.Map
+	for i := 0; i < benchSyncN; i++ {
+		s.Store(i, i)
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		var i int
+		for pb.Next() {
+			s.Load(i % benchSyncN)
+			i++
+		}
+	})
+}
+
+func BenchmarkMutexMapGet(b *testing.B) {
+	var s mutexMap[int, int]
+	for i := 0; i < benchSyncN; i++ {
+		s.Set(i, i)
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		var i int
+		for pb.Next() {
+			s.Get(i % benchSyncN)
+			i++
+		}
+	})
+}
+
+// The read-mostly benchmarks write once for every 1000 reads.
+
+func BenchmarkSyncMapReadMostly(b *testing.B) {
+	var s SyncMap[int, int]
+	s.Update(func(m *Map[int, int]) {
+		for i := 0; i < benchSyncN; i++ {
+			m.Set(i, i)
+		}
+	})
+	b.RunParallel(func(pb *testing.PB) {
+		var i int
+		for pb.Next() {
+			if i%1000 == 0 {
+				s.Set(i%benchSyncN, i)
+			} else {
+				s.Get(i % benchSyncN)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkStdSyncMapReadMostly(b *testing.B) {
+	var s sync.Map
+	for i := 0; i < benchSyncN; i++ {
+		s.Store(i, i)
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		var i int
+		for pb.Next() {
+			if i%1000 == 0 {
+				s.Store(i%benchSyncN, i)
+			} else {
+				s.Load(i % benchSyncN)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkMutexMapReadMostly(b *testing.B) {
+	var s mutexMap[int, int]
+	for i := 0; i < benchSyncN; i++ {
+		s.Set(i, i)
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		var i int
+		for pb.Next() {
+			if i%1000 == 0 {
+				s.Set(i%benchSyncN, i)
+			} else {
+				s.Get(i % benchSyncN)
+			}
+			i++
+		}
+	})
+}